@@ -26,6 +26,29 @@ import (
 type APISubscription struct {
 	APIContextPath string `json:"api_context_path,omitempty"`
 	APIPlanName    string `json:"api_plan_name,omitempty"`
+
+	// Optional date the subscription starts
+	StartingAt *metav1.Time `json:"starting_at,omitempty"`
+
+	// Optional date the subscription ends, expired subscriptions are closed
+	EndingAt *metav1.Time `json:"ending_at,omitempty"`
+
+	// Pause the subscription, it is resumed when unset
+	Paused bool `json:"paused,omitempty"`
+}
+
+type APISubscriptionStatus struct {
+	APIContextPath string `json:"api_context_path,omitempty"`
+	APIPlanName    string `json:"api_plan_name,omitempty"`
+
+	// Subscription's uuid.
+	// Example: 00f8c9e7-78fc-4907-b8c9-e778fc790750
+	ID string `json:"id,omitempty"`
+
+	// The status of the subscription.
+	// Example: ACCEPTED
+	// Enum: [PENDING ACCEPTED PAUSED REJECTED CLOSED EXPIRED]
+	Status string `json:"status,omitempty"`
 }
 
 // APIClientSpec defines the desired state of APIClient
@@ -36,6 +59,9 @@ type APIClientSpec struct {
 	// Name of the Client App
 	Name string `json:"name,omitempty"`
 
+	// GraviteeConnection managing the Client App, the operator configuration file is used when unset
+	Connection *ConnectionReference `json:"connection,omitempty"`
+
 	// Description of the Client App
 	Description string `json:"description,omitempty"`
 
@@ -65,6 +91,13 @@ type APIClientStatus struct {
 	// The last reconcyled generation.
 	// Example: 1
 	UpdatedGeneration int64 `json:"updated_generation,omitempty"`
+
+	// The status of the API subscriptions
+	Subscriptions []APISubscriptionStatus `json:"subscriptions,omitempty"`
+
+	// The Synced condition tells whether the Application is in sync with the management API,
+	// its reason being the class of the last error otherwise.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
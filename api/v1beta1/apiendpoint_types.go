@@ -17,6 +17,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -105,7 +106,170 @@ type Path struct {
 	Rules []*Rule `json:"rules"`
 }
 
+// ValueSource selects a value from a key of a Secret or a ConfigMap
+type ValueSource struct {
+	// Selects a key of a Secret
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// Selects a key of a ConfigMap
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// Metadata declares a metadata entry of the API
+type Metadata struct {
+	// name
+	// Required: true
+	Name string `json:"name"`
+
+	// format
+	// +kubebuilder:validation:Enum=STRING;NUMERIC;BOOLEAN;DATE;MAIL;URL
+	Format string `json:"format,omitempty"`
+
+	// value
+	Value string `json:"value,omitempty"`
+
+	// hidden in the portal
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// Page declares a documentation page of the API
+type Page struct {
+	// name, unique among the pages of the API
+	// Required: true
+	Name string `json:"name"`
+
+	// type
+	// Required: true
+	// +kubebuilder:validation:Enum=MARKDOWN;SWAGGER;ASCIIDOC;FOLDER
+	Type string `json:"type"`
+
+	// name of the parent folder, declared before the page
+	Folder string `json:"folder,omitempty"`
+
+	// order of the page in its folder
+	Order int32 `json:"order,omitempty"`
+
+	// published in the portal
+	Published bool `json:"published,omitempty"`
+
+	// homepage of the API in the portal
+	Homepage bool `json:"homepage,omitempty"`
+
+	// key of a ConfigMap in the namespace of the APIEndpoint holding the content of the page, unused by folders
+	ContentFrom *corev1.ConfigMapKeySelector `json:"contentFrom,omitempty"`
+}
+
+type PageStatus struct {
+	// name
+	Name string `json:"name,omitempty"`
+
+	// Page's uuid.
+	ID string `json:"id,omitempty"`
+}
+
+// ResponseTemplate replaces the response of the gateway for an error
+type ResponseTemplate struct {
+	// HTTP status
+	Status int32 `json:"status,omitempty"`
+
+	// headers
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// body
+	Body string `json:"body,omitempty"`
+}
+
+// ResponseTemplates holds the response templates of an error, by accepted media type
+type ResponseTemplates map[string]ResponseTemplate
+
+// Logging configures the logging of the calls to the API by the gateway
+type Logging struct {
+	// logged side of the proxy
+	// +kubebuilder:validation:Enum=NONE;CLIENT;PROXY;CLIENT_PROXY
+	Mode string `json:"mode,omitempty"`
+
+	// logged content
+	// +kubebuilder:validation:Enum=NONE;HEADERS;PAYLOADS;HEADERS_PAYLOADS
+	Content string `json:"content,omitempty"`
+
+	// logged phase of the call
+	// +kubebuilder:validation:Enum=NONE;REQUEST;RESPONSE;REQUEST_RESPONSE
+	Scope string `json:"scope,omitempty"`
+
+	// EL condition to select the logged calls
+	// Example: {#request.headers['X-Debug'] != null}
+	Condition string `json:"condition,omitempty"`
+}
+
+// VirtualHost exposes the API on a host and a path
+type VirtualHost struct {
+	// host, the API is exposed on all the hosts of the gateway when empty
+	Host string `json:"host,omitempty"`
+
+	// context path
+	// Required: true
+	Path string `json:"path"`
+
+	// use the host and path of this virtual host as the entrypoint of the API in the portal
+	OverrideEntrypoint bool `json:"override_entrypoint,omitempty"`
+}
+
+// TLS configures the connection to the backend
+type TLS struct {
+	// trust any backend certificate, false when not set
+	TrustAll *bool `json:"trust_all,omitempty"`
+
+	// verify that the backend certificate matches the hostname, true when not set
+	HostnameVerifier *bool `json:"hostname_verifier,omitempty"`
+
+	// key of a Secret holding the CA bundle, in PEM format, trusted to verify the backend certificate
+	CASecretRef *corev1.SecretKeySelector `json:"ca_secret_ref,omitempty"`
+
+	// name of a kubernetes.io/tls Secret holding the client certificate and key for mutual TLS
+	ClientCertSecretName string `json:"client_cert_secret_name,omitempty"`
+}
+
+// Property declares an API property, read by the policies
+type Property struct {
+	// key
+	// Required: true
+	Key string `json:"key"`
+
+	// literal value
+	Value string `json:"value,omitempty"`
+
+	// value taken from a Secret or a ConfigMap in the namespace of the APIEndpoint,
+	// Secret values are stored as encrypted properties
+	ValueFrom *ValueSource `json:"valueFrom,omitempty"`
+}
+
+// Resource declares a resource of the API, e.g. an OAuth2 server, a cache or an authentication provider
+type Resource struct {
+	// name of the resource, referenced by the plans and the policies
+	// Required: true
+	Name string `json:"name"`
+
+	// type of the resource
+	// Example: oauth2, oauth2-am-resource, cache, auth-provider-http-resource, auth-provider-inline-resource, auth-provider-ldap-resource
+	// Required: true
+	Type string `json:"type"`
+
+	// enabled, resources are enabled by default
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// configuration of the resource, as a JSON document
+	Configuration string `json:"configuration,omitempty"`
+
+	// configuration values taken from Secrets or ConfigMaps in the namespace of the APIEndpoint,
+	// e.g. the client secret of an OAuth2 resource. They override the top level keys of configuration.
+	ConfigurationFrom map[string]ValueSource `json:"configurationFrom,omitempty"`
+}
+
 type Plan struct {
+	// stable key of the plan, stored as the cross ID of the Gravitee plan, which is then
+	// matched by key so that it can be renamed in place
+	Key string `json:"key,omitempty"`
+
 	// description
 	// Required: true
 	Description string `json:"description"`
@@ -126,9 +290,75 @@ type Plan struct {
 	// security definition
 	SecurityDefinition map[string]string `json:"securityDefinition,omitempty"`
 
+	// security definition values taken from Secrets or ConfigMaps in the namespace of the APIEndpoint,
+	// e.g. a JWT public key or JWKS URL. They override the values in securityDefinition.
+	SecurityDefinitionFrom map[string]ValueSource `json:"securityDefinitionFrom,omitempty"`
+
+	// name of the OAuth2 resource used by an OAUTH2 plan
+	OAuthResource string `json:"oauthResource,omitempty"`
+
 	// tags
 	// Unique: true
 	Tags []string `json:"tags"`
+
+	// subscription validation, MANUAL subscriptions must be approved with an APISubscriptionApproval
+	// +kubebuilder:validation:Enum=AUTO;MANUAL
+	Validation string `json:"validation,omitempty"`
+
+	// deprecated, the plan does not accept new subscriptions but existing ones are kept,
+	// ignored when the status is set
+	Deprecated bool `json:"deprecated,omitempty"`
+
+	// status of the plan, STAGING plans are prepared without being available to subscribers,
+	// CLOSED plans with active subscriptions are only closed with the force plan removal annotation.
+	// The status wins over the deprecated flag.
+	// +kubebuilder:validation:Enum=STAGING;PUBLISHED;DEPRECATED;CLOSED
+	Status string `json:"status,omitempty"`
+
+	// name of the plan existing subscriptions are transferred to
+	MigrateTo string `json:"migrateTo,omitempty"`
+
+	// display order of the plan in the portal, the current order is kept when not set
+	Order *int32 `json:"order,omitempty"`
+
+	// characteristics of the plan displayed in the portal
+	Characteristics []string `json:"characteristics,omitempty"`
+
+	// groups not allowed to subscribe to the plan
+	ExcludedGroups []string `json:"excludedGroups,omitempty"`
+
+	// a comment is required from the subscriber
+	CommentRequired bool `json:"commentRequired,omitempty"`
+
+	// message displayed to the subscriber when a comment is required
+	CommentMessage string `json:"commentMessage,omitempty"`
+
+	// EL condition to select the plan when several plans share the same security type
+	SelectionRule string `json:"selectionRule,omitempty"`
+
+	// name of the page of pages holding the general conditions of the plan
+	GeneralConditions string `json:"generalConditions,omitempty"`
+}
+
+type PlanStatus struct {
+	// name
+	Name string `json:"name,omitempty"`
+
+	// stable key of the plan
+	Key string `json:"key,omitempty"`
+
+	// Plan's uuid.
+	// Example: 00f8c9e7-78fc-4907-b8c9-e778fc790750
+	ID string `json:"id,omitempty"`
+
+	// The status of the plan.
+	// Example: PUBLISHED
+	// Enum: [STAGING PUBLISHED DEPRECATED CLOSED]
+	Status string `json:"status,omitempty"`
+
+	// Name of the plan all the subscriptions have been transferred to, the
+	// migration is not run again while migrateTo names the same plan
+	MigratedTo string `json:"migratedTo,omitempty"`
 }
 
 // APIEndpointSpec defines the desired state of APIEndpoint
@@ -141,12 +371,27 @@ type APIEndpointSpec struct {
 	// API's version
 	Version string `json:"version,omitempty"`
 
+	// GraviteeConnection managing the API, the operator configuration file is used when unset
+	Connection *ConnectionReference `json:"connection,omitempty"`
+
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	// API's context path.
 	// Example: /my-awesome-api
 	ContextPath string `json:"context_path,omitempty"`
 
+	// path mappings grouping the calls by resource in the analytics.
+	// Example: /users/:id
+	// Unique: true
+	PathMappings []string `json:"path_mappings,omitempty"`
+
+	// name of the OpenAPI page of pages the path mappings are imported from, each time the API is
+	// updated. The imported path mappings are merged with path_mappings.
+	PathMappingsFromPage string `json:"path_mappings_from_page,omitempty"`
+
+	// virtual hosts the API is exposed on, context_path is a shorthand for a single virtual host with a path only
+	VirtualHosts []*VirtualHost `json:"virtual_hosts,omitempty"`
+
 	// API's description. A short description of your API.
 	// Example: I can use a hundred characters to describe this API.
 	Description string `json:"description,omitempty"`
@@ -160,9 +405,46 @@ type APIEndpointSpec struct {
 	// CORS
 	Cors *Cors `json:"cors,omitempty"`
 
+	// TLS options of the connection to the backend
+	TLS *TLS `json:"tls,omitempty"`
+
+	// response templates, by error key and accepted media type
+	// Example: API_KEY_MISSING
+	ResponseTemplates map[string]ResponseTemplates `json:"response_templates,omitempty"`
+
+	// logging of the calls to the API
+	Logging *Logging `json:"logging,omitempty"`
+
+	// documentation pages of the API
+	Pages []*Page `json:"pages,omitempty"`
+
+	// categories of the API in the portal, by name or key
+	Categories []string `json:"categories,omitempty"`
+
+	// groups of users the API belongs to, by name
+	Groups []string `json:"groups,omitempty"`
+
+	// labels of the API in the portal
+	Labels []string `json:"labels,omitempty"`
+
+	// mirror the Kubernetes labels of the APIEndpoint as labels of the API, as <key>:<value>
+	MirrorLabels bool `json:"mirror_labels,omitempty"`
+
+	// keys of the Kubernetes annotations of the APIEndpoint mirrored as labels of the API, as <key>:<value>
+	MirrorAnnotations []string `json:"mirror_annotations,omitempty"`
+
+	// metadata of the API, matched by name. Metadata not declared here are kept.
+	Metadata []*Metadata `json:"metadata,omitempty"`
+
 	// Plans
 	Plans []*Plan `json:"plans"`
 
+	// Resources of the API, referenced by name from the plans and the policies
+	Resources []*Resource `json:"resources,omitempty"`
+
+	// Properties of the API
+	Properties []*Property `json:"properties,omitempty"`
+
 	// The status of the API regarding the gateway.
 	// Example: STARTED
 	// Enum: [INITIALIZED STOPPED STARTED CLOSED]
@@ -195,6 +477,23 @@ type APIEndpointStatus struct {
 	// The last reconcyled generation.
 	// Example: 1
 	UpdatedGeneration int64 `json:"updated_generation,omitempty"`
+
+	// The status of the API plans
+	Plans []PlanStatus `json:"plans,omitempty"`
+
+	// The documentation pages of the API
+	Pages []PageStatus `json:"pages,omitempty"`
+
+	// The names of the hidden metadata at the last update
+	HiddenMetadata []string `json:"hidden_metadata,omitempty"`
+
+	// Hash of the UIDs and resource versions of the Secrets and ConfigMaps the values are taken
+	// from at the last update, a change redeploys the API.
+	ReferencedValuesHash string `json:"referenced_values_hash,omitempty"`
+
+	// The Synced condition tells whether the API is in sync with the management API,
+	// its reason being the class of the last error otherwise.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
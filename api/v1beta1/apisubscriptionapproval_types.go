@@ -0,0 +1,107 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// APISubscriptionApprovalSpec defines the desired state of APISubscriptionApproval
+type APISubscriptionApprovalSpec struct {
+	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// Name of the APIEndpoint, in the same namespace, the subscription belongs to
+	APIEndpointName string `json:"api_endpoint_name"`
+
+	// Name of the manually validated plan the subscription has been requested for
+	APIPlanName string `json:"api_plan_name"`
+
+	// Name of the APIClient requesting the subscription
+	APIClientName string `json:"api_client_name"`
+
+	// Namespace of the APIClient, defaults to the namespace of the approval
+	APIClientNamespace string `json:"api_client_namespace,omitempty"`
+
+	// Accept (true) or reject (false) the pending subscription
+	Accepted bool `json:"accepted"`
+
+	// Reason of the decision, sent to the subscriber
+	Reason string `json:"reason,omitempty"`
+
+	// Optional end date of an accepted subscription
+	EndingAt *metav1.Time `json:"ending_at,omitempty"`
+}
+
+// APISubscriptionApprovalStatus defines the observed state of APISubscriptionApproval
+type APISubscriptionApprovalStatus struct {
+	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// Subscription's uuid.
+	// Example: 00f8c9e7-78fc-4907-b8c9-e778fc790750
+	SubscriptionID string `json:"subscription_id,omitempty"`
+
+	// The status of the subscription after processing.
+	// Example: ACCEPTED
+	// Enum: [PENDING ACCEPTED REJECTED]
+	SubscriptionStatus string `json:"subscription_status,omitempty"`
+
+	// The date (as a timestamp) when the subscription was processed.
+	// Example: 1581256457163
+	ProcessedAt int64 `json:"processed_at,omitempty"`
+
+	// The decision applied to the subscription, it is not processed again afterwards.
+	// Enum: [ACCEPTED REJECTED]
+	Decision string `json:"decision,omitempty"`
+
+	// The last reconcyled generation.
+	// Example: 1
+	UpdatedGeneration int64 `json:"updated_generation,omitempty"`
+
+	// The Synced condition tells whether the decision has been applied, its reason
+	// being NotFoundError while the subscription has not been requested.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// APISubscriptionApproval is the Schema for the apisubscriptionapprovals API
+type APISubscriptionApproval struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   APISubscriptionApprovalSpec   `json:"spec,omitempty"`
+	Status APISubscriptionApprovalStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// APISubscriptionApprovalList contains a list of APISubscriptionApproval
+type APISubscriptionApprovalList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []APISubscriptionApproval `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&APISubscriptionApproval{}, &APISubscriptionApprovalList{})
+}
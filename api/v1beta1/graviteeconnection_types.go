@@ -0,0 +1,145 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// ConnectionReference selects the GraviteeConnection used to manage a resource
+type ConnectionReference struct {
+	// Name of the GraviteeConnection
+	Name string `json:"name"`
+
+	// Namespace of the GraviteeConnection, defaults to the namespace of the referencing resource.
+	// A GraviteeConnection of another namespace must allow the namespace of the referencing resource.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// GraviteeConnectionSpec defines the desired state of GraviteeConnection
+type GraviteeConnectionSpec struct {
+	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// Host of the management API.
+	// Example: apim.my.domain
+	Host string `json:"host"`
+
+	// Base path of the management API.
+	// Example: /management
+	Path string `json:"path,omitempty"`
+
+	// Scheme of the management API.
+	// +kubebuilder:validation:Enum=http;https
+	Scheme string `json:"scheme,omitempty"`
+
+	// ID of the organization.
+	// Example: DEFAULT
+	Organization string `json:"organization,omitempty"`
+
+	// ID of the environment.
+	// Example: DEFAULT
+	Environment string `json:"environment,omitempty"`
+
+	// Timeout of the management API calls, in seconds
+	Timeout int `json:"timeout,omitempty"`
+
+	// Rate of the management API calls, per second, 20 when not set.
+	// Zero means no limit.
+	// +kubebuilder:validation:Minimum=0
+	RateLimit *int `json:"rate_limit,omitempty"`
+
+	// Maximum burst of management API calls, 40 when not set
+	// +kubebuilder:validation:Minimum=0
+	RateLimitBurst *int `json:"rate_limit_burst,omitempty"`
+
+	// Maximum number of management API calls in flight, 10 when not set.
+	// Zero means no limit.
+	// +kubebuilder:validation:Minimum=0
+	MaxInFlight *int `json:"max_in_flight,omitempty"`
+
+	// Secret, in the namespace of the connection, holding the credentials:
+	// either a token key or user and password keys, and client_id and client_secret
+	// keys for the OAuth2 authentication modes
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentials_secret_ref,omitempty"`
+
+	// Secret, in the namespace of the connection, holding a CA bundle (ca.crt) and a
+	// client certificate and key (tls.crt and tls.key) for mutual TLS
+	TLSSecretRef *corev1.LocalObjectReference `json:"tls_secret_ref,omitempty"`
+
+	// Minimum TLS version of the management API calls.
+	// +kubebuilder:validation:Enum="1.0";"1.1";"1.2";"1.3"
+	MinTLSVersion string `json:"min_tls_version,omitempty"`
+
+	// HTTP proxy of the management API calls.
+	// Example: http://proxy.my.domain:3128
+	Proxy string `json:"proxy,omitempty"`
+
+	// Authentication mode, the credentials being used as they are when empty.
+	// client_credentials uses an access token of the OAuth2 token endpoint,
+	// token_exchange exchanges it for a Gravitee token and login logs in with the user and password.
+	// +kubebuilder:validation:Enum=client_credentials;token_exchange;login
+	AuthMode string `json:"auth_mode,omitempty"`
+
+	// OAuth2 token endpoint, for client_credentials and token_exchange.
+	// Example: https://am.my.domain/my-domain/oauth/token
+	TokenURL string `json:"token_url,omitempty"`
+
+	// OAuth2 scope requested to the token endpoint
+	Scope string `json:"scope,omitempty"`
+
+	// Gravitee identity provider the access tokens are exchanged with, for token_exchange
+	TokenExchangeIdentity string `json:"token_exchange_identity,omitempty"`
+
+	// Namespaces, besides the one of the connection, whose resources may use the connection
+	AllowedNamespaces []string `json:"allowed_namespaces,omitempty"`
+}
+
+// GraviteeConnectionStatus defines the observed state of GraviteeConnection
+type GraviteeConnectionStatus struct {
+	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// GraviteeConnection is the Schema for the graviteeconnections API
+type GraviteeConnection struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GraviteeConnectionSpec   `json:"spec,omitempty"`
+	Status GraviteeConnectionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GraviteeConnectionList contains a list of GraviteeConnection
+type GraviteeConnectionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GraviteeConnection `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GraviteeConnection{}, &GraviteeConnectionList{})
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	l "log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
+)
+
+const (
+	// period of the collection of the API analytics
+	analyticsInterval = 10 * time.Second
+	// number of APIs whose analytics are read at once
+	analyticsWorkers = 4
+)
+
+var (
+	apiCalls = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravitee_api_endpoint_count",
+			Help: "Number of api calls",
+		},
+		[]string{"name", "namespace", "api_id"})
+	avgResponseTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravitee_api_endpoint_avg",
+			Help: "Average response time",
+		},
+		[]string{"name", "namespace", "api_id"})
+	minResponseTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravitee_api_endpoint_min",
+			Help: "Minimum response time",
+		},
+		[]string{"name", "namespace", "api_id"})
+	maxResponseTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravitee_api_endpoint_max",
+			Help: "Maximum response time",
+		},
+		[]string{"name", "namespace", "api_id"})
+	pathMappingCalls = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravitee_api_endpoint_path_mapping_count",
+			Help: "Number of api calls by path mapping",
+		},
+		[]string{"name", "namespace", "api_id", "path_mapping"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiCalls, avgResponseTime, minResponseTime, maxResponseTime, pathMappingCalls)
+}
+
+// analyticsCollector exports the analytics of the APIEndpoints as metrics. It runs on the
+// leader only, listing the APIEndpoints from the manager cache, and removes the metrics of
+// the APIs which are gone.
+type analyticsCollector struct {
+	client.Client
+	Connections *Connections
+	Interval    time.Duration
+
+	// the labels of the metrics exported by the last collection
+	apiLabels         map[string]prometheus.Labels
+	pathMappingLabels map[string]prometheus.Labels
+}
+
+// Start collects the analytics every interval until the context is done
+func (a *analyticsCollector) Start(ctx context.Context) error {
+	l.Printf("starting the analytics collector")
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Printf("stopping the analytics collector")
+			return nil
+		case <-ticker.C:
+			a.collect(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection makes the collector run on the leader only
+func (a *analyticsCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// collect reads the analytics of the APIs with analyticsWorkers workers, within the interval.
+// The connections are resolved once per collection, the APIs of a broken or unavailable
+// connection being skipped: their metrics keep their last value until the APIs are gone.
+func (a *analyticsCollector) collect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, a.Interval)
+	defer cancel()
+	apiEndpoints := platformv1beta1.APIEndpointList{}
+	if err := a.List(ctx, &apiEndpoints); err != nil {
+		l.Printf("unable to list APIEndpoints: %s", err)
+		return
+	}
+	collection := &analyticsCollection{
+		ctx:               ctx,
+		connections:       a.Connections,
+		controllers:       make(map[string]*analyticsConnection),
+		pathMappingLabels: make(map[string]prometheus.Labels),
+		pathMappingsRead:  make(map[string]bool),
+	}
+	apiLabels := make(map[string]prometheus.Labels)
+	queue := make(chan *platformv1beta1.APIEndpoint)
+	wg := sync.WaitGroup{}
+	for i := 0; i < analyticsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for apiEndpoint := range queue {
+				collection.collectAPI(apiEndpoint)
+			}
+		}()
+	}
+	for i := range apiEndpoints.Items {
+		apiEndpoint := &apiEndpoints.Items[i]
+		if apiEndpoint.Status.ID == "" || !apiEndpoint.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+		labels := apiEndpointLabels(apiEndpoint)
+		apiLabels[labelsKey(labels)] = labels
+		queue <- apiEndpoint
+	}
+	close(queue)
+	wg.Wait()
+	if ctx.Err() != nil {
+		l.Printf("the collection of the analytics took longer than %s", a.Interval)
+	}
+	// remove the metrics of the APIs which are gone, and of the path mappings an API no longer reports
+	for key, labels := range a.apiLabels {
+		if _, ok := apiLabels[key]; !ok {
+			apiCalls.Delete(labels)
+			avgResponseTime.Delete(labels)
+			minResponseTime.Delete(labels)
+			maxResponseTime.Delete(labels)
+		}
+	}
+	for key, labels := range a.pathMappingLabels {
+		if _, ok := collection.pathMappingLabels[key]; ok {
+			continue
+		}
+		apiKey := labelsKey(prometheus.Labels{"name": labels["name"], "namespace": labels["namespace"], "api_id": labels["api_id"]})
+		if _, ok := apiLabels[apiKey]; ok && !collection.pathMappingsRead[apiKey] {
+			collection.pathMappingLabels[key] = labels
+			continue
+		}
+		pathMappingCalls.Delete(labels)
+	}
+	a.apiLabels = apiLabels
+	a.pathMappingLabels = collection.pathMappingLabels
+}
+
+// analyticsCollection is a collection of the analytics in progress, shared by its workers
+type analyticsCollection struct {
+	ctx         context.Context
+	connections *Connections
+
+	mu          sync.Mutex
+	controllers map[string]*analyticsConnection
+	// the labels of the path mappings reported, by API read
+	pathMappingLabels map[string]prometheus.Labels
+	pathMappingsRead  map[string]bool
+}
+
+// analyticsConnection is a connection resolved once per collection
+type analyticsConnection struct {
+	once sync.Once
+	// nil when the connection is broken
+	controller *APIController
+}
+
+// controller returns the APIController of the connection of an APIEndpoint, nil when the
+// connection is broken. Each connection is resolved once, outside the lock, so that a slow
+// connection only holds back the workers of its own APIs.
+func (c *analyticsCollection) controller(apiEndpoint *platformv1beta1.APIEndpoint) *APIController {
+	key := DefaultConnectionName
+	if ref := apiEndpoint.Spec.Connection; ref != nil {
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = apiEndpoint.Namespace
+		}
+		// the namespace of the APIEndpoint is part of the key as it must be allowed to use the connection
+		key = apiEndpoint.Namespace + ">" + namespace + "/" + ref.Name
+	}
+	c.mu.Lock()
+	connection, ok := c.controllers[key]
+	if !ok {
+		connection = &analyticsConnection{}
+		c.controllers[key] = connection
+	}
+	c.mu.Unlock()
+	connection.once.Do(func() {
+		controller, err := c.connections.Get(c.ctx, apiEndpoint.Spec.Connection, apiEndpoint.Namespace)
+		if err != nil {
+			l.Printf("error getting connection of api %s: %s", apiEndpoint.Name, err)
+			return
+		}
+		connection.controller = controller
+	})
+	return connection.controller
+}
+
+func (c *analyticsCollection) collectAPI(apiEndpoint *platformv1beta1.APIEndpoint) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	controller := c.controller(apiEndpoint)
+	if controller == nil || controller.Unavailable() > 0 {
+		return
+	}
+	labels := apiEndpointLabels(apiEndpoint)
+	analytics, err := controller.GetAPIAnalytics(apiEndpoint, c.ctx)
+	if err != nil {
+		l.Printf("error getting analytics from API management: %s", err)
+		return
+	}
+	apiCalls.With(labels).Set(analytics["count"])
+	avgResponseTime.With(labels).Set(analytics["avg"])
+	minResponseTime.With(labels).Set(analytics["min"])
+	maxResponseTime.With(labels).Set(analytics["max"])
+	pathMappingAnalytics := map[string]float64{}
+	if len(apiEndpoint.Spec.PathMappings) > 0 || apiEndpoint.Spec.PathMappingsFromPage != "" {
+		pathMappingAnalytics, err = controller.GetAPIPathMappingAnalytics(apiEndpoint, c.ctx)
+		if err != nil {
+			l.Printf("error getting path mapping analytics from API management: %s", err)
+			return
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pathMappingsRead[labelsKey(labels)] = true
+	for pathMapping, hits := range pathMappingAnalytics {
+		labels := prometheus.Labels{"name": apiEndpoint.Name, "namespace": apiEndpoint.Namespace, "api_id": apiEndpoint.Status.ID, "path_mapping": pathMapping}
+		c.pathMappingLabels[labelsKey(labels)] = labels
+		pathMappingCalls.With(labels).Set(hits)
+	}
+}
+
+// apiEndpointLabels returns the labels of the metrics of an APIEndpoint
+func apiEndpointLabels(apiEndpoint *platformv1beta1.APIEndpoint) prometheus.Labels {
+	return prometheus.Labels{"name": apiEndpoint.Name, "namespace": apiEndpoint.Namespace, "api_id": apiEndpoint.Status.ID}
+}
+
+func labelsKey(labels prometheus.Labels) string {
+	return labels["namespace"] + "/" + labels["name"] + "/" + labels["api_id"] + "/" + labels["path_mapping"]
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLabelsKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels prometheus.Labels
+		want   string
+	}{
+		{"api", prometheus.Labels{"name": "orders", "namespace": "shop", "api_id": "api-1"}, "shop/orders/api-1/"},
+		{"path mapping", prometheus.Labels{"name": "orders", "namespace": "shop", "api_id": "api-1", "path_mapping": "/orders/:id"}, "shop/orders/api-1//orders/:id"},
+		{"other api id", prometheus.Labels{"name": "orders", "namespace": "shop", "api_id": "api-2"}, "shop/orders/api-2/"},
+		{"no labels", prometheus.Labels{}, "///"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labelsKey(tt.labels); got != tt.want {
+				t.Errorf("labelsKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
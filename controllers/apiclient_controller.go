@@ -18,12 +18,14 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	record "k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	log "sigs.k8s.io/controller-runtime/pkg/log"
 
 	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
@@ -33,14 +35,18 @@ import (
 // APIClientReconciler reconciles a APIClient object
 type APIClientReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	APIController
-	recorder record.EventRecorder
+	Scheme      *runtime.Scheme
+	Connections *Connections
+
+	// MaxConcurrentReconciles is the number of APIClients reconciled concurrently, 1 when not set
+	MaxConcurrentReconciles int
+	recorder                record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=platform.my.domain,resources=apiclients,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=platform.my.domain,resources=apiclients/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=platform.my.domain,resources=apiclients/finalizers,verbs=update
+//+kubebuilder:rbac:groups=platform.my.domain,resources=apiendpoints,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -64,6 +70,18 @@ func (r *APIClientReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// get the management API connection of the Application
+	c, err := r.Connections.Get(ctx, apiClient.Spec.Connection, apiClient.Namespace)
+	if err != nil {
+		log.V(0).Info("unable to get connection", "error", err)
+		r.recorder.Event(&apiClient, v1.EventTypeNormal, "Error", "Unable to get GraviteeConnection")
+		return r.failed(&apiClient, err, ctx)
+	}
+	if wait := c.Unavailable(); wait > 0 {
+		log.V(0).Info("management API unavailable, pausing", "retryAfter", wait)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
 	apiClientFinalizerName := "apiclient.platform.my.domain/finalizer"
 
 	// examine DeletionTimestamp to determine if object is under deletion
@@ -81,10 +99,12 @@ func (r *APIClientReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		// The object is being deleted
 		if containsString(apiClient.GetFinalizers(), apiClientFinalizerName) {
 			// our finalizer is present, so lets handle any external dependency
-			if err := r.DeleteApplication(&apiClient); err != nil {
+			if err := c.DeleteApplication(&apiClient); err != nil {
 				// if fail to delete the external dependency here, return with error
 				// so that it can be retried
-				return ctrl.Result{}, err
+				log.V(0).Info("unable to delete Application", "error", err)
+				r.recorder.Event(&apiClient, v1.EventTypeNormal, "Error", "Unable to delete Application")
+				return r.failed(&apiClient, err, ctx)
 			}
 
 			// remove our finalizer from the list and update it.
@@ -100,51 +120,119 @@ func (r *APIClientReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	if apiClient.Status.ID != "" {
 		// Application already exists
-		app, err := r.GetApplication(apiClient.Status.ID)
+		app, err := c.GetApplication(apiClient.Status.ID)
 		if err != nil {
 			log.V(0).Info("unable to get Application", "error", err)
 			r.recorder.Event(&apiClient, v1.EventTypeNormal, "Error", "Unable to get Application")
-			return ctrl.Result{}, err
+			return r.failed(&apiClient, err, ctx)
 		}
-		if apiClient.Status.UpdatedGeneration < apiClient.ObjectMeta.Generation || apiClient.Status.UpdatedAt < app.UpdatedAt {
+		if apiClient.Status.UpdatedGeneration < apiClient.ObjectMeta.Generation || apiClient.Status.UpdatedAt < app.UpdatedAt || hasExpiredSubscriptions(&apiClient) {
 			log.V(0).Info("updating the app")
-			err := r.UpdateApplication(&apiClient)
+			err := c.UpdateApplication(&apiClient)
 			if err != nil {
 				log.V(0).Info("nable to update Application", "error", err)
 				r.recorder.Event(&apiClient, v1.EventTypeNormal, "Error", "Unable to update Application")
-				return ctrl.Result{}, err
+				return r.failed(&apiClient, err, ctx)
 			}
 			r.recorder.Event(&apiClient, v1.EventTypeNormal, "Ok", "Updated Application")
+			if err := r.UpdateSubscriptions(c, &apiClient, ctx); err != nil {
+				return r.failed(&apiClient, err, ctx)
+			}
 			r.UpdateCRD(&apiClient, ctx)
-			r.UpdateAPISubscriptions(&apiClient, ctx)
 		}
 	} else {
-		app, err := r.CreateApplication(&apiClient)
+		app, err := c.CreateApplication(&apiClient)
 		if err != nil {
 			log.V(0).Info("error creating Application", "error", err)
 			r.recorder.Event(&apiClient, v1.EventTypeNormal, "Error", "Unable to create Application")
-			return ctrl.Result{}, err
+			return r.failed(&apiClient, err, ctx)
 		}
 		r.recorder.Event(&apiClient, v1.EventTypeNormal, "Ok", "Created Application")
 
 		apiClient.Status.ID = app.ID
+		if err := r.UpdateSubscriptions(c, &apiClient, ctx); err != nil {
+			return r.failed(&apiClient, err, ctx)
+		}
 		r.UpdateCRD(&apiClient, ctx)
-		r.UpdateAPISubscriptions(&apiClient, ctx)
+	}
+	if hasEndingSubscriptions(&apiClient) {
+		// check again later for subscriptions to expire
+		scheduledResult := ctrl.Result{RequeueAfter: time.Duration(r.Connections.Config().ReschedulePeriod) * time.Second}
+		return scheduledResult, nil
 	}
 	return ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *APIClientReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	r.Init()
 	r.recorder = mgr.GetEventRecorderFor("APIEndpoint")
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&platformv1beta1.APIClient{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
 
+func (r *APIClientReconciler) UpdateSubscriptions(c *APIController, apiClient *platformv1beta1.APIClient, ctx context.Context) error {
+	log := log.FromContext(ctx)
+	// the APIEndpoints resolve the plans of the subscriptions
+	apiEndpoints := platformv1beta1.APIEndpointList{}
+	if err := r.List(ctx, &apiEndpoints); err != nil {
+		log.V(0).Info("unable to list APIEndpoints", "error", err)
+		return err
+	}
+	if err := c.UpdateAPISubscriptions(apiClient, apiEndpoints.Items, ctx); err != nil {
+		log.V(0).Info("unable to update subscriptions", "error", err)
+		r.recorder.Event(apiClient, v1.EventTypeNormal, "Error", "Unable to update subscriptions")
+		return err
+	}
+	r.recorder.Event(apiClient, v1.EventTypeNormal, "Ok", "Updated subscriptions")
+	return nil
+}
+
 func (r *APIClientReconciler) UpdateCRD(apiClient *platformv1beta1.APIClient, ctx context.Context) error {
 	apiClient.Status.UpdatedGeneration = apiClient.ObjectMeta.Generation
+	setSyncedCondition(&apiClient.Status.Conditions, apiClient.ObjectMeta.Generation, nil)
 	err := r.Status().Update(ctx, apiClient)
 	return err
 }
+
+// failed reports the management API error in the Synced condition and returns the result
+// of the reconcile for its class
+func (r *APIClientReconciler) failed(apiClient *platformv1beta1.APIClient, err error, ctx context.Context) (ctrl.Result, error) {
+	setSyncedCondition(&apiClient.Status.Conditions, apiClient.ObjectMeta.Generation, err)
+	if updateErr := r.Status().Update(ctx, apiClient); updateErr != nil {
+		log.FromContext(ctx).V(0).Info("unable to update the status", "error", updateErr)
+	}
+	return managementResult(err, r.Connections.Config().ReschedulePeriod)
+}
+
+// hasExpiredSubscriptions checks for subscriptions past their end date not yet reported as expired
+func hasExpiredSubscriptions(apiClient *platformv1beta1.APIClient) bool {
+	now := time.Now()
+	for _, sub := range apiClient.Spec.APISubscriptions {
+		if sub.EndingAt == nil || sub.EndingAt.Time.After(now) {
+			continue
+		}
+		expired := false
+		for _, sub_status := range apiClient.Status.Subscriptions {
+			if sub_status.APIContextPath == sub.APIContextPath && sub_status.APIPlanName == sub.APIPlanName && sub_status.Status == "EXPIRED" {
+				expired = true
+			}
+		}
+		if !expired {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEndingSubscriptions checks for subscriptions with an end date still to come
+func hasEndingSubscriptions(apiClient *platformv1beta1.APIClient) bool {
+	now := time.Now()
+	for _, sub := range apiClient.Spec.APISubscriptions {
+		if sub.EndingAt != nil && sub.EndingAt.Time.After(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -18,9 +18,9 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
-	"github.com/madflojo/tasks"
-	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	types "k8s.io/apimachinery/pkg/types"
@@ -28,8 +28,11 @@ import (
 	l "log"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"time"
 
 	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
@@ -40,43 +43,21 @@ import (
 // APIEndpointReconciler reconciles a APIEndpoint object
 type APIEndpointReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	APIController
-	recorder  record.EventRecorder
-	scheduler *tasks.Scheduler
-	apis      map[string]platformv1beta1.APIEndpoint
+	Scheme      *runtime.Scheme
+	Connections *Connections
+
+	// MaxConcurrentReconciles is the number of APIEndpoints reconciled concurrently, 1 when not set
+	MaxConcurrentReconciles int
+	recorder                record.EventRecorder
 }
 
-var (
-	count = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "gravitee_api_endpoint_count",
-			Help: "Number of api calls",
-		},
-		[]string{"name", "namespace", "api_id"})
-	avg = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "gravitee_api_endpoint_avg",
-			Help: "Average response time",
-		},
-		[]string{"name", "namespace", "api_id"})
-	min = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "gravitee_api_endpoint_min",
-			Help: "Minimum response time",
-		},
-		[]string{"name", "namespace", "api_id"})
-	max = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "gravitee_api_endpoint_max",
-			Help: "Maximum response time",
-		},
-		[]string{"name", "namespace", "api_id"})
-)
+// referencedValuesIndex indexes the APIEndpoints by the Secrets and ConfigMaps they reference
+const referencedValuesIndex = ".spec.referencedValues"
 
 //+kubebuilder:rbac:groups=platform.my.domain,resources=apigateways,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=platform.my.domain,resources=apigateways/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=platform.my.domain,resources=apigateways/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets;configmaps,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -100,6 +81,18 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// get the management API connection of the API
+	c, err := r.Connections.Get(ctx, apiEndpoint.Spec.Connection, apiEndpoint.Namespace)
+	if err != nil {
+		log.V(0).Info("unable to get connection", "error", err)
+		r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Unable to get GraviteeConnection")
+		return r.failed(&apiEndpoint, err, ctx)
+	}
+	if wait := c.Unavailable(); wait > 0 {
+		log.V(0).Info("management API unavailable, pausing", "retryAfter", wait)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
 	apiEndpointFinalizerName := "apiendpoint.platform.my.domain/finalizer"
 
 	// examine DeletionTimestamp to determine if object is under deletion
@@ -117,10 +110,12 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// The object is being deleted
 		if containsString(apiEndpoint.GetFinalizers(), apiEndpointFinalizerName) {
 			// our finalizer is present, so lets handle any external dependency
-			if err := r.DeleteAPI(&apiEndpoint); err != nil {
+			if err := c.DeleteAPI(&apiEndpoint); err != nil {
 				// if fail to delete the external dependency here, return with error
 				// so that it can be retried
-				return ctrl.Result{}, err
+				log.V(0).Info("error deleting API", "error", err)
+				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error deleting API")
+				return r.failed(&apiEndpoint, err, ctx)
 			}
 			log.V(0).Info("api deleted", "ID", apiEndpoint.Status.ID)
 			// remove our finalizer from the list and update it.
@@ -136,8 +131,8 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	if apiEndpoint.Status.ID != "" {
 		log.V(0).Info("api already exists", "ID", apiEndpoint.Status.ID)
-		api, err := r.GetAPI(apiEndpoint.Status.ID)
-		if err != nil {
+		api, err := c.GetAPI(apiEndpoint.Status.ID)
+		if IsNotFound(err) {
 			log.V(0).Info("api not configured", "ID", apiEndpoint.Status.ID)
 			apiEndpoint.Status.ID = ""
 			apiEndpoint.Status.UpdatedAt = 0
@@ -148,9 +143,22 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			if err != nil {
 				log.V(0).Info("error update CRD", "error", err)
 			}
+			// create it again
+			return ctrl.Result{Requeue: true}, err
+		}
+		if err != nil {
+			log.V(0).Info("error getting API", "error", err)
+			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error getting API")
+			return r.failed(&apiEndpoint, err, ctx)
+		}
+		resolvedValues, err := r.ResolveReferencedValues(&apiEndpoint, ctx)
+		if err != nil {
+			log.V(0).Info("error resolving referenced values", "error", err)
+			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error resolving referenced values")
 			return ctrl.Result{}, err
 		}
-		if apiEndpoint.Status.UpdatedGeneration < apiEndpoint.ObjectMeta.Generation || apiEndpoint.Status.UpdatedAt < api.UpdatedAt {
+		referencedValuesHash := hashReferencedValues(resolvedValues)
+		if apiEndpoint.Status.UpdatedGeneration < apiEndpoint.ObjectMeta.Generation || apiEndpoint.Status.UpdatedAt < api.UpdatedAt || apiEndpoint.Status.ReferencedValuesHash != referencedValuesHash {
 			log.V(0).Info("updating the api")
 
 			target, err := r.GetAPITarget(&apiEndpoint, ctx)
@@ -159,36 +167,53 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error getting target for API")
 				return ctrl.Result{}, err
 			}
-			if err = r.UpdateAPI(&apiEndpoint, target, ctx); err != nil {
+			if err = c.UpdateAPI(&apiEndpoint, target, resolvedValues, ctx); err != nil {
 				log.V(0).Info("error updating API", "error", err)
 				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error updating API")
-				return ctrl.Result{}, err
+				return r.failed(&apiEndpoint, err, ctx)
 			}
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Updated API")
-			err = r.UpdateAPIPlans(&apiEndpoint)
+			err = c.UpdateAPIPages(&apiEndpoint, resolvedValues)
+			if err != nil {
+				log.V(0).Info("error update pages", "error", err)
+				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error update API pages")
+				return r.failed(&apiEndpoint, err, ctx)
+			}
+			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Updated API pages")
+			if err = c.ImportAPIPathMappings(&apiEndpoint); err != nil {
+				log.V(0).Info("error importing path mappings", "error", err)
+				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error importing API path mappings")
+				return r.failed(&apiEndpoint, err, ctx)
+			}
+			err = c.UpdateAPIPlans(&apiEndpoint, resolvedValues)
 			if err != nil {
 				log.V(0).Info("error update plans", "error", err)
-				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error update API plans")
-				return ctrl.Result{}, err
+				if ErrorClassOf(err) == ErrorValidation {
+					r.recorder.Event(&apiEndpoint, v1.EventTypeWarning, "InvalidPlan", err.Error())
+				} else {
+					r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error update API plans")
+				}
+				return r.failed(&apiEndpoint, err, ctx)
 			}
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Updated API plans")
 
-			if err = r.DeployAPI(api.ID); err != nil {
+			if err = c.DeployAPI(api.ID); err != nil {
 				log.V(0).Info("error deploying API", "error", err)
 				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error deploying API")
-				return ctrl.Result{}, err
+				return r.failed(&apiEndpoint, err, ctx)
 			}
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Deployed API")
 
-			api, err := r.GetAPI(apiEndpoint.Status.ID)
+			api, err := c.GetAPI(apiEndpoint.Status.ID)
 			if err != nil {
 				log.V(0).Info("error getting API", "error", err)
 				r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error getting API")
-				return ctrl.Result{}, err
+				return r.failed(&apiEndpoint, err, ctx)
 			}
 			apiEndpoint.Status.ID = api.ID
 			apiEndpoint.Status.UpdatedAt = api.UpdatedAt
 			apiEndpoint.Status.UpdatedGeneration = apiEndpoint.ObjectMeta.Generation
+			apiEndpoint.Status.ReferencedValuesHash = referencedValuesHash
 
 			err = r.UpdateCRD(&apiEndpoint, ctx)
 			if err != nil {
@@ -199,17 +224,19 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			log.V(0).Info("api crd updated")
 			log.V(0).Info("api updated")
 		}
-		r.apis[apiEndpoint.Status.ID] = apiEndpoint
-		for apiId, apiEndpoint := range r.apis {
-			l.Printf("api id %s => api name: %s", apiId, apiEndpoint.Name)
-		}
 	} else {
 		log.V(0).Info("api not configured, creating it")
-		api, err := r.CreateAPI(&apiEndpoint)
+		resolvedValues, err := r.ResolveReferencedValues(&apiEndpoint, ctx)
+		if err != nil {
+			log.V(0).Info("error resolving referenced values", "error", err)
+			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error resolving referenced values")
+			return ctrl.Result{}, err
+		}
+		api, err := c.CreateAPI(&apiEndpoint)
 		if err != nil {
 			log.V(0).Info("error creating API", "error", err)
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error getting API")
-			return ctrl.Result{}, err
+			return r.failed(&apiEndpoint, err, ctx)
 		}
 
 		r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Create API")
@@ -223,37 +250,50 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 		apiEndpoint.Status.ID = api.Payload.ID
 
-		if err = r.UpdateAPI(&apiEndpoint, target, ctx); err != nil {
+		if err = c.UpdateAPI(&apiEndpoint, target, resolvedValues, ctx); err != nil {
 			log.V(0).Info("error updating API", "error", err)
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error updating API")
-			return ctrl.Result{}, err
+			return r.failed(&apiEndpoint, err, ctx)
 		}
 		r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Update API")
 
-		err = r.UpdateAPIPlans(&apiEndpoint)
+		err = c.UpdateAPIPages(&apiEndpoint, resolvedValues)
+		if err != nil {
+			log.V(0).Info("error update pages", "error", err)
+			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error updating API pages")
+			return r.failed(&apiEndpoint, err, ctx)
+		}
+		r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Update API pages")
+		if err = c.ImportAPIPathMappings(&apiEndpoint); err != nil {
+			log.V(0).Info("error importing path mappings", "error", err)
+			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error importing API path mappings")
+			return r.failed(&apiEndpoint, err, ctx)
+		}
+		err = c.UpdateAPIPlans(&apiEndpoint, resolvedValues)
 		if err != nil {
 			log.V(0).Info("error update plans", "error", err)
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error updating API Plans")
-			return ctrl.Result{}, err
+			return r.failed(&apiEndpoint, err, ctx)
 		}
 		r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Update API plans")
 
-		if err = r.DeployAPI(apiEndpoint.Status.ID); err != nil {
+		if err = c.DeployAPI(apiEndpoint.Status.ID); err != nil {
 			log.V(0).Info("error deploying API", "error", err)
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error deploying API")
-			return ctrl.Result{}, err
+			return r.failed(&apiEndpoint, err, ctx)
 		}
 		r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Ok", "Deploy API")
 
-		api_updated, err := r.GetAPI(apiEndpoint.Status.ID)
+		api_updated, err := c.GetAPI(apiEndpoint.Status.ID)
 		if err != nil {
 			log.V(0).Info("error getting API", "error", err)
 			r.recorder.Event(&apiEndpoint, v1.EventTypeNormal, "Error", "Error getting API")
-			return ctrl.Result{}, err
+			return r.failed(&apiEndpoint, err, ctx)
 		}
 
 		apiEndpoint.Status.UpdatedAt = api_updated.UpdatedAt
 		apiEndpoint.Status.UpdatedGeneration = apiEndpoint.ObjectMeta.Generation
+		apiEndpoint.Status.ReferencedValuesHash = hashReferencedValues(resolvedValues)
 		err = r.UpdateCRD(&apiEndpoint, ctx)
 		if err != nil {
 			log.V(0).Info("error update CRD", "error", err)
@@ -261,28 +301,101 @@ func (r *APIEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 		log.V(0).Info("api crd updated")
 		log.V(0).Info("api created")
-
-		r.apis[apiEndpoint.Status.ID] = apiEndpoint
-		for apiId, apiEndpoint := range r.apis {
-			l.Printf("api id %s => api name: %s", apiId, apiEndpoint.Name)
-		}
 	}
-	scheduledResult := ctrl.Result{RequeueAfter: time.Duration(r.config["reschedule_period"].(int)) * time.Second}
+	scheduledResult := ctrl.Result{RequeueAfter: time.Duration(r.Connections.Config().ReschedulePeriod) * time.Second}
 	return scheduledResult, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *APIEndpointReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	r.Init()
-	metrics.Registry.MustRegister(count, avg, min, max)
-	r.apis = make(map[string]platformv1beta1.APIEndpoint)
-	r.GetMetrics()
 	r.recorder = mgr.GetEventRecorderFor("APIEndpoint")
+	if err := mgr.Add(&analyticsCollector{Client: mgr.GetClient(), Connections: r.Connections, Interval: analyticsInterval}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &platformv1beta1.APIEndpoint{}, referencedValuesIndex, func(obj client.Object) []string {
+		return referencedValues(obj.(*platformv1beta1.APIEndpoint))
+	}); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&platformv1beta1.APIEndpoint{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findAPIEndpointsReferencing("secret"))).
+		Watches(&source.Kind{Type: &v1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.findAPIEndpointsReferencing("configmap"))).
 		Complete(r)
 }
 
+// findAPIEndpointsReferencing maps a Secret or a ConfigMap to the APIEndpoints referencing it
+func (r *APIEndpointReconciler) findAPIEndpointsReferencing(kind string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		apiEndpoints := platformv1beta1.APIEndpointList{}
+		err := r.List(context.Background(), &apiEndpoints,
+			client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{referencedValuesIndex: kind + "/" + obj.GetName()})
+		if err != nil {
+			l.Printf("unable to list APIEndpoints referencing %s %s: %s", kind, obj.GetName(), err)
+			return nil
+		}
+		requests := make([]reconcile.Request, len(apiEndpoints.Items))
+		for i, apiEndpoint := range apiEndpoints.Items {
+			requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: apiEndpoint.Name, Namespace: apiEndpoint.Namespace}}
+		}
+		return requests
+	}
+}
+
+// referencedValues lists the Secrets and ConfigMaps referenced by an APIEndpoint, as <kind>/<name>
+func referencedValues(apiEndpoint *platformv1beta1.APIEndpoint) []string {
+	refs := make([]string, 0)
+	for _, plan := range apiEndpoint.Spec.Plans {
+		for _, valueSource := range plan.SecurityDefinitionFrom {
+			refs = append(refs, valueSourceRef(&valueSource)...)
+		}
+	}
+	for _, resource := range apiEndpoint.Spec.Resources {
+		for _, valueSource := range resource.ConfigurationFrom {
+			refs = append(refs, valueSourceRef(&valueSource)...)
+		}
+	}
+	for _, property := range apiEndpoint.Spec.Properties {
+		if property.ValueFrom != nil {
+			refs = append(refs, valueSourceRef(property.ValueFrom)...)
+		}
+	}
+	for _, page := range apiEndpoint.Spec.Pages {
+		if page.ContentFrom != nil {
+			refs = append(refs, "configmap/"+page.ContentFrom.Name)
+		}
+	}
+	if tls := apiEndpoint.Spec.TLS; tls != nil {
+		if tls.CASecretRef != nil {
+			refs = append(refs, "secret/"+tls.CASecretRef.Name)
+		}
+		if tls.ClientCertSecretName != "" {
+			refs = append(refs, "secret/"+tls.ClientCertSecretName)
+		}
+	}
+	return refs
+}
+
+func valueSourceRef(valueSource *platformv1beta1.ValueSource) []string {
+	refs := make([]string, 0)
+	if valueSource.SecretKeyRef != nil {
+		refs = append(refs, "secret/"+valueSource.SecretKeyRef.Name)
+	}
+	if valueSource.ConfigMapKeyRef != nil {
+		refs = append(refs, "configmap/"+valueSource.ConfigMapKeyRef.Name)
+	}
+	return refs
+}
+
+// hashReferencedValues computes the hash of the versions of the Secrets and ConfigMaps the values
+// are taken from, so that the status does not tell anything about their content
+func hashReferencedValues(values *ResolvedValues) string {
+	versions_raw, _ := json.Marshal(values.Versions)
+	return fmt.Sprintf("%x", sha256.Sum256(versions_raw))
+}
+
 func (r *APIEndpointReconciler) GetServiceByName(apiEndpoint *platformv1beta1.APIEndpoint, ctx context.Context) (*string, error) {
 	service := v1.Service{}
 	namespacedName := types.NamespacedName{
@@ -293,22 +406,202 @@ func (r *APIEndpointReconciler) GetServiceByName(apiEndpoint *platformv1beta1.AP
 		l.Printf("unable to retrieve Service %s", err)
 		return nil, err
 	}
+	config := r.Connections.Config()
 	var protocol string
 	if service.Spec.Ports[0].AppProtocol != nil {
 		protocol = *service.Spec.Ports[0].AppProtocol
 	} else {
-		protocol = r.config["service_default_protocol"].(string)
+		protocol = config.ServiceDefaultProtocol
 	}
-	target := fmt.Sprintf("%s://%s.%s.svc.%s:%d/%s", protocol, namespacedName.Name, namespacedName.Namespace, r.config["service_default_domain"].(string), service.Spec.Ports[0].Port, apiEndpoint.Spec.Target)
+	target := fmt.Sprintf("%s://%s.%s.svc.%s:%d/%s", protocol, namespacedName.Name, namespacedName.Namespace, config.ServiceDefaultDomain, service.Spec.Ports[0].Port, apiEndpoint.Spec.Target)
 	return &target, nil
 }
 
+// ResolveValueSource reads the value of a key of a Secret or a ConfigMap in the given namespace
+func (r *APIEndpointReconciler) ResolveValueSource(valueSource *platformv1beta1.ValueSource, namespace string, resolvedValues *ResolvedValues, ctx context.Context) (string, error) {
+	if ref := valueSource.SecretKeyRef; ref != nil {
+		secret := v1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, &secret); err != nil {
+			if ref.Optional != nil && *ref.Optional {
+				resolvedValues.track("Secret", ref.Name, nil)
+				return "", nil
+			}
+			return "", err
+		}
+		resolvedValues.track("Secret", ref.Name, &secret)
+		if value, ok := secret.Data[ref.Key]; ok {
+			return string(value), nil
+		}
+		if ref.Optional != nil && *ref.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("key %s not found in Secret %s", ref.Key, ref.Name)
+	}
+	if ref := valueSource.ConfigMapKeyRef; ref != nil {
+		configMap := v1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, &configMap); err != nil {
+			if ref.Optional != nil && *ref.Optional {
+				resolvedValues.track("ConfigMap", ref.Name, nil)
+				return "", nil
+			}
+			return "", err
+		}
+		resolvedValues.track("ConfigMap", ref.Name, &configMap)
+		if value, ok := configMap.Data[ref.Key]; ok {
+			return value, nil
+		}
+		if value, ok := configMap.BinaryData[ref.Key]; ok {
+			return string(value), nil
+		}
+		if ref.Optional != nil && *ref.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("key %s not found in ConfigMap %s", ref.Key, ref.Name)
+	}
+	return "", fmt.Errorf("no Secret or ConfigMap key selected")
+}
+
+// ResolvedValues holds the parts of an APIEndpoint completed with the values taken from Secrets and ConfigMaps
+type ResolvedValues struct {
+	// security definition of each plan, by plan name
+	SecurityDefinitions map[string]map[string]string
+
+	// configuration of each resource, by resource name
+	Resources map[string]string
+
+	// value of each property, by property key
+	Properties map[string]string
+
+	// content of each page, by page name
+	Pages map[string]string
+
+	// CA bundle trusted to verify the backend certificate, in PEM format
+	CACertificate string
+
+	// client certificate and key for mutual TLS with the backend, in PEM format
+	ClientCertificate string
+	ClientKey         string
+
+	// UID and resource version of each referenced Secret and ConfigMap, by kind and name,
+	// empty for optional ones not found
+	Versions map[string]string
+}
+
+// track records the version of a referenced Secret or ConfigMap, nil when it does not exist
+func (v *ResolvedValues) track(kind string, name string, obj client.Object) {
+	version := ""
+	if obj != nil {
+		version = fmt.Sprintf("%s/%s", obj.GetUID(), obj.GetResourceVersion())
+	}
+	v.Versions[kind+"/"+name] = version
+}
+
+// ResolveReferencedValues resolves the values the APIEndpoint takes from Secrets and ConfigMaps
+func (r *APIEndpointReconciler) ResolveReferencedValues(apiEndpoint *platformv1beta1.APIEndpoint, ctx context.Context) (*ResolvedValues, error) {
+	resolvedValues := ResolvedValues{
+		SecurityDefinitions: make(map[string]map[string]string),
+		Resources:           make(map[string]string),
+		Properties:          make(map[string]string),
+		Pages:               make(map[string]string),
+		Versions:            make(map[string]string),
+	}
+	for _, page := range apiEndpoint.Spec.Pages {
+		if page.ContentFrom != nil {
+			content, err := r.ResolveValueSource(&platformv1beta1.ValueSource{ConfigMapKeyRef: page.ContentFrom}, apiEndpoint.Namespace, &resolvedValues, ctx)
+			if err != nil {
+				return nil, err
+			}
+			resolvedValues.Pages[page.Name] = content
+		}
+	}
+	if tls := apiEndpoint.Spec.TLS; tls != nil {
+		if tls.CASecretRef != nil {
+			caCertificate, err := r.ResolveValueSource(&platformv1beta1.ValueSource{SecretKeyRef: tls.CASecretRef}, apiEndpoint.Namespace, &resolvedValues, ctx)
+			if err != nil {
+				return nil, err
+			}
+			resolvedValues.CACertificate = caCertificate
+		}
+		if tls.ClientCertSecretName != "" {
+			secret := v1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Name: tls.ClientCertSecretName, Namespace: apiEndpoint.Namespace}, &secret); err != nil {
+				return nil, err
+			}
+			resolvedValues.track("Secret", secret.Name, &secret)
+			if secret.Type != v1.SecretTypeTLS {
+				return nil, fmt.Errorf("Secret %s is not of type %s", secret.Name, v1.SecretTypeTLS)
+			}
+			resolvedValues.ClientCertificate = string(secret.Data[v1.TLSCertKey])
+			resolvedValues.ClientKey = string(secret.Data[v1.TLSPrivateKeyKey])
+		}
+	}
+	for _, property := range apiEndpoint.Spec.Properties {
+		value := property.Value
+		if property.ValueFrom != nil {
+			var err error
+			if value, err = r.ResolveValueSource(property.ValueFrom, apiEndpoint.Namespace, &resolvedValues, ctx); err != nil {
+				return nil, err
+			}
+		}
+		resolvedValues.Properties[property.Key] = value
+	}
+	for _, resource := range apiEndpoint.Spec.Resources {
+		configuration := make(map[string]interface{})
+		if resource.Configuration != "" {
+			if err := json.Unmarshal([]byte(resource.Configuration), &configuration); err != nil {
+				return nil, fmt.Errorf("invalid configuration of resource %s: %s", resource.Name, err)
+			}
+		}
+		for k, valueSource := range resource.ConfigurationFrom {
+			value, err := r.ResolveValueSource(&valueSource, apiEndpoint.Namespace, &resolvedValues, ctx)
+			if err != nil {
+				return nil, err
+			}
+			configuration[k] = value
+		}
+		configuration_raw, _ := json.Marshal(configuration)
+		resolvedValues.Resources[resource.Name] = string(configuration_raw)
+	}
+	for _, plan := range apiEndpoint.Spec.Plans {
+		securityDefinition := make(map[string]string)
+		for k, v := range plan.SecurityDefinition {
+			securityDefinition[k] = v
+		}
+		for k, valueSource := range plan.SecurityDefinitionFrom {
+			value, err := r.ResolveValueSource(&valueSource, apiEndpoint.Namespace, &resolvedValues, ctx)
+			if err != nil {
+				return nil, err
+			}
+			securityDefinition[k] = value
+		}
+		if plan.OAuthResource != "" {
+			if _, ok := resolvedValues.Resources[plan.OAuthResource]; !ok {
+				return nil, fmt.Errorf("plan %s references the unknown resource %s", *plan.Name, plan.OAuthResource)
+			}
+			securityDefinition["oauthResource"] = plan.OAuthResource
+		}
+		resolvedValues.SecurityDefinitions[*plan.Name] = securityDefinition
+	}
+	return &resolvedValues, nil
+}
+
 func (r *APIEndpointReconciler) UpdateCRD(apiEndpoint *platformv1beta1.APIEndpoint, ctx context.Context) error {
 	apiEndpoint.Status.UpdatedGeneration = apiEndpoint.ObjectMeta.Generation
+	setSyncedCondition(&apiEndpoint.Status.Conditions, apiEndpoint.ObjectMeta.Generation, nil)
 	err := r.Status().Update(ctx, apiEndpoint)
 	return err
 }
 
+// failed reports the management API error in the Synced condition and returns the result
+// of the reconcile for its class
+func (r *APIEndpointReconciler) failed(apiEndpoint *platformv1beta1.APIEndpoint, err error, ctx context.Context) (ctrl.Result, error) {
+	setSyncedCondition(&apiEndpoint.Status.Conditions, apiEndpoint.ObjectMeta.Generation, err)
+	if updateErr := r.Status().Update(ctx, apiEndpoint); updateErr != nil {
+		l.Printf("unable to update the status of APIEndpoint %s: %s", apiEndpoint.Name, updateErr)
+	}
+	return managementResult(err, r.Connections.Config().ReschedulePeriod)
+}
+
 func (r *APIEndpointReconciler) GetAPITarget(apiEndpoint *platformv1beta1.APIEndpoint, ctx context.Context) (*string, error) {
 	var target *string
 	var err error
@@ -319,34 +612,3 @@ func (r *APIEndpointReconciler) GetAPITarget(apiEndpoint *platformv1beta1.APIEnd
 	}
 	return target, err
 }
-
-func (r *APIEndpointReconciler) GetMetrics() error {
-	// Start the Scheduler
-	r.scheduler = tasks.New()
-
-	// Add a task
-	l.Printf("adding task to get analytics")
-	_, err := r.scheduler.Add(&tasks.Task{
-		Interval: time.Duration(10 * time.Second),
-		TaskFunc: func() error {
-			// l.Printf("now getting analytics from API management")
-			for _, apiEndpoint := range r.apis {
-				l.Printf("getting analytics for api id %s => api name: %s - namespace: %s", apiEndpoint.Status.ID, apiEndpoint.Name, apiEndpoint.Namespace)
-				analytics, err := r.GetAPIAnalytics(&apiEndpoint)
-				if err != nil {
-					l.Printf("error getting analytics from API management: %s", err)
-				}
-				count.With(prometheus.Labels{"name": apiEndpoint.Name, "namespace": apiEndpoint.Namespace, "api_id": apiEndpoint.Status.ID}).Set(analytics["count"])
-				avg.With(prometheus.Labels{"name": apiEndpoint.Name, "namespace": apiEndpoint.Namespace, "api_id": apiEndpoint.Status.ID}).Set(analytics["avg"])
-				min.With(prometheus.Labels{"name": apiEndpoint.Name, "namespace": apiEndpoint.Namespace, "api_id": apiEndpoint.Status.ID}).Set(analytics["min"])
-				max.With(prometheus.Labels{"name": apiEndpoint.Name, "namespace": apiEndpoint.Namespace, "api_id": apiEndpoint.Status.ID}).Set(analytics["max"])
-				// l.Printf("updated metrics for API: %s, count: %v", apiEndpoint.Name, analytics["count"])
-			}
-			return nil
-		},
-	})
-	if err != nil {
-		l.Printf("error getting analytics from API management: %s", err)
-	}
-	return nil
-}
@@ -0,0 +1,213 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	types "k8s.io/apimachinery/pkg/types"
+	record "k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	log "sigs.k8s.io/controller-runtime/pkg/log"
+
+	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
+)
+
+// APISubscriptionApprovalReconciler reconciles a APISubscriptionApproval object
+type APISubscriptionApprovalReconciler struct {
+	client.Client
+	Scheme      *runtime.Scheme
+	Connections *Connections
+	recorder    record.EventRecorder
+
+	// MaxConcurrentReconciles is the number of APISubscriptionApprovals reconciled concurrently, 1 when not set
+	MaxConcurrentReconciles int
+}
+
+//+kubebuilder:rbac:groups=platform.my.domain,resources=apisubscriptionapprovals,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=platform.my.domain,resources=apisubscriptionapprovals/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=platform.my.domain,resources=apiendpoints;apiclients,verbs=get;list;watch
+//+kubebuilder:rbac:groups=platform.my.domain,resources=apiclients/status,verbs=get;update;patch
+
+// Reconcile accepts or rejects the pending subscription of an APIClient to a
+// manually validated plan of an APIEndpoint, as declared by the approval.
+// The decision is applied once and kept in the status, later changes of the
+// approval are ignored; while the subscription has not been requested yet,
+// the reconciliation is rescheduled.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *APISubscriptionApprovalReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var approval platformv1beta1.APISubscriptionApproval
+	if err := r.Get(ctx, req.NamespacedName, &approval); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !approval.ObjectMeta.DeletionTimestamp.IsZero() || approval.Status.Decision != "" {
+		// already processed, a decision can not be reverted
+		return ctrl.Result{}, nil
+	}
+	scheduledResult := ctrl.Result{RequeueAfter: time.Duration(r.Connections.Config().ReschedulePeriod) * time.Second}
+
+	var apiEndpoint platformv1beta1.APIEndpoint
+	if err := r.Get(ctx, types.NamespacedName{Name: approval.Spec.APIEndpointName, Namespace: approval.Namespace}, &apiEndpoint); err != nil {
+		log.V(0).Info("unable to get APIEndpoint", "error", err)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get APIEndpoint")
+		return ctrl.Result{}, err
+	}
+	apiClientNamespace := approval.Spec.APIClientNamespace
+	if apiClientNamespace == "" {
+		apiClientNamespace = approval.Namespace
+	}
+	var apiClient platformv1beta1.APIClient
+	if err := r.Get(ctx, types.NamespacedName{Name: approval.Spec.APIClientName, Namespace: apiClientNamespace}, &apiClient); err != nil {
+		log.V(0).Info("unable to get APIClient", "error", err)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get APIClient")
+		return ctrl.Result{}, err
+	}
+	if apiEndpoint.Status.ID == "" || apiClient.Status.ID == "" {
+		log.V(0).Info("api or application not configured yet")
+		return scheduledResult, nil
+	}
+	c, err := r.Connections.Get(ctx, apiEndpoint.Spec.Connection, apiEndpoint.Namespace)
+	if err != nil {
+		log.V(0).Info("unable to get connection", "error", err)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get GraviteeConnection")
+		return managementResult(err, r.Connections.Config().ReschedulePeriod)
+	}
+	if wait := c.Unavailable(); wait > 0 {
+		log.V(0).Info("management API unavailable, pausing", "retryAfter", wait)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	plan_new := apiEndpointPlan(&apiEndpoint, approval.Spec.APIPlanName)
+	if plan_new == nil {
+		log.V(0).Info("plan not declared by the APIEndpoint", "plan", approval.Spec.APIPlanName)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get Plan")
+		return scheduledResult, nil
+	}
+	plan, err := c.FindAPIPlan(apiEndpoint.Status.ID, plan_new, apiEndpoint.Spec.Plans)
+	if err != nil {
+		log.V(0).Info("unable to get Plan", "error", err)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get Plan")
+		return managementResult(err, r.Connections.Config().ReschedulePeriod)
+	}
+	subscriptionID, err := c.GetPendingAPISubscription(apiEndpoint.Status.ID, apiClient.Status.ID, plan.ID)
+	if err != nil {
+		log.V(0).Info("unable to get pending subscription", "error", err)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get pending subscription")
+		return managementResult(err, r.Connections.Config().ReschedulePeriod)
+	}
+	if subscriptionID == "" {
+		// the decision may have been applied by a reconcile which failed to record it
+		decision := "REJECTED"
+		if approval.Spec.Accepted {
+			decision = "ACCEPTED"
+		}
+		subs, err := c.GetAPISubscriptions(apiEndpoint.Status.ID, apiClient.Status.ID, plan.ID, decision)
+		if err != nil {
+			log.V(0).Info("unable to get processed subscriptions", "error", err)
+			r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to get processed subscriptions")
+			return managementResult(err, r.Connections.Config().ReschedulePeriod)
+		}
+		if sub := processedSubscription(subs, approval.Status.SubscriptionID); sub != nil {
+			log.V(0).Info("subscription already processed", "ID", subscriptionString(sub, "id"), "status", decision)
+			r.recorder.Event(&approval, v1.EventTypeNormal, "Ok", "Subscription already processed")
+			approval.Status.SubscriptionID = subscriptionString(sub, "id")
+			approval.Status.SubscriptionStatus = subscriptionString(sub, "status")
+			approval.Status.ProcessedAt = subscriptionTimestamp(sub, "processed_at")
+			approval.Status.Decision = decision
+			if err = r.UpdateCRD(&approval, ctx); err != nil {
+				return ctrl.Result{}, err
+			}
+			err = r.UpdateAPIClientSubscription(&apiClient, approval.Status.SubscriptionID, approval.Status.SubscriptionStatus, ctx)
+			return ctrl.Result{}, err
+		}
+		log.V(0).Info("no pending subscription", "plan", approval.Spec.APIPlanName, "application", apiClient.Status.ID)
+		err = newManagementError(ErrorNotFound, "no pending subscription of APIClient %s to plan %s", apiClient.Name, approval.Spec.APIPlanName)
+		approval.Status.SubscriptionID = ""
+		approval.Status.SubscriptionStatus = ""
+		setSyncedCondition(&approval.Status.Conditions, approval.ObjectMeta.Generation, err)
+		if err := r.Status().Update(ctx, &approval); err != nil {
+			return ctrl.Result{}, err
+		}
+		return scheduledResult, nil
+	}
+
+	approval.Status.SubscriptionID = subscriptionID
+	sub, err := c.ProcessAPISubscription(apiEndpoint.Status.ID, &approval)
+	if err != nil {
+		log.V(0).Info("unable to process subscription", "error", err)
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Error", "Unable to process subscription")
+		return managementResult(err, r.Connections.Config().ReschedulePeriod)
+	}
+	if approval.Spec.Accepted {
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Ok", "Accepted subscription")
+	} else {
+		r.recorder.Event(&approval, v1.EventTypeNormal, "Ok", "Rejected subscription")
+	}
+	log.V(0).Info("subscription processed", "ID", sub.ID, "status", sub.Status)
+
+	approval.Status.SubscriptionStatus = sub.Status
+	approval.Status.ProcessedAt = sub.ProcessedAt
+	approval.Status.Decision = "REJECTED"
+	if approval.Spec.Accepted {
+		approval.Status.Decision = "ACCEPTED"
+	}
+	if err = r.UpdateCRD(&approval, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.UpdateAPIClientSubscription(&apiClient, sub.ID, sub.Status, ctx)
+	return ctrl.Result{}, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *APISubscriptionApprovalReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorder = mgr.GetEventRecorderFor("APISubscriptionApproval")
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&platformv1beta1.APISubscriptionApproval{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r)
+}
+
+func (r *APISubscriptionApprovalReconciler) UpdateCRD(approval *platformv1beta1.APISubscriptionApproval, ctx context.Context) error {
+	approval.Status.UpdatedGeneration = approval.ObjectMeta.Generation
+	setSyncedCondition(&approval.Status.Conditions, approval.ObjectMeta.Generation, nil)
+	err := r.Status().Update(ctx, approval)
+	return err
+}
+
+// UpdateAPIClientSubscription reports the new status of a processed subscription in the
+// status of the APIClient, which is otherwise only refreshed when the APIClient changes
+func (r *APISubscriptionApprovalReconciler) UpdateAPIClientSubscription(apiClient *platformv1beta1.APIClient, subscriptionID string, status string, ctx context.Context) error {
+	for i := range apiClient.Status.Subscriptions {
+		sub_status := &apiClient.Status.Subscriptions[i]
+		if sub_status.ID != subscriptionID || sub_status.Status == status {
+			continue
+		}
+		sub_status.Status = status
+		return r.Status().Update(ctx, apiClient)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	l "log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+
+	gravitee_auth "my.domain/platform/gk8soperator/pkg/gravitee/client/authentication"
+	gravitee_user "my.domain/platform/gk8soperator/pkg/gravitee/client/current_user"
+)
+
+// Authentication modes of the management API calls, besides the static user and password or token
+const (
+	AuthModeClientCredentials = "client_credentials"
+	AuthModeTokenExchange     = "token_exchange"
+	AuthModeLogin             = "login"
+)
+
+// tokens are renewed this long before they expire
+const tokenRefreshMargin = 30 * time.Second
+
+// tokenSource obtains a token, caches it and obtains a new one when it is about to expire
+// or has been rejected
+type tokenSource struct {
+	fetch func(ctx context.Context) (string, time.Time, error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Token returns the cached token, or a new one
+func (s *tokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(s.expiry)) {
+		return s.token, nil
+	}
+	token, expiry, err := s.fetch(ctx)
+	if err != nil {
+		l.Printf("unable to obtain a management API token: %s", err)
+		return "", err
+	}
+	s.token = token
+	s.expiry = expiry
+	return token, nil
+}
+
+// Invalidate drops the given token, if it is still the cached one
+func (s *tokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// tokenTransport sets the bearer token of the requests, and sends a request again with a
+// new token when the management API rejects the cached one
+type tokenTransport struct {
+	next   http.RoundTripper
+	source *tokenSource
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req, body, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	t.source.Invalidate(token)
+	if token, err = t.source.Token(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.send(req, body, token)
+}
+
+func (t *tokenTransport) send(req *http.Request, body []byte, token string) (*http.Response, error) {
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		authReq.Body = io.NopCloser(bytes.NewReader(body))
+		authReq.ContentLength = int64(len(body))
+	}
+	return t.next.RoundTrip(authReq)
+}
+
+// newTokenSource returns the token source of the authentication mode of the configuration,
+// the Gravitee tokens being obtained through the given management API transport and the
+// OAuth2 access tokens through a client of their own
+func newTokenSource(config *Config, transport http.RoundTripper) (*tokenSource, error) {
+	var tokenClient *http.Client
+	if config.TokenURL != "" {
+		var err error
+		if tokenClient, err = newTokenEndpointClient(config); err != nil {
+			return nil, err
+		}
+	}
+	authRuntime := httptransport.New(config.Host, config.Path, []string{config.Schemes})
+	authRuntime.Transport = transport
+	switch config.AuthMode {
+	case AuthModeClientCredentials:
+		return &tokenSource{fetch: clientCredentials(config, tokenClient)}, nil
+	case AuthModeLogin:
+		client_user := gravitee_user.New(authRuntime, strfmt.Default)
+		authInfo := httptransport.BasicAuth(config.User, config.Password)
+		return &tokenSource{fetch: func(ctx context.Context) (string, time.Time, error) {
+			loginParams := gravitee_user.NewLoginParams()
+			loginParams.WithDefaults()
+			loginParams.SetOrgID(config.Organization)
+			loginParams.SetEnvID(config.Environment)
+			loginParams.SetTimeout(time.Second * time.Duration(callTimeout(config)))
+			loginParams.SetContext(ctx)
+			resp, err := client_user.Login(loginParams, authInfo)
+			if err != nil {
+				return "", time.Time{}, fmt.Errorf("unable to log in: %w", err)
+			}
+			return resp.Payload.Token, jwtExpiry(resp.Payload.Token), nil
+		}}, nil
+	case AuthModeTokenExchange:
+		client_auth := gravitee_auth.New(authRuntime, strfmt.Default)
+		subjectToken := tokenFile(config.TokenFile)
+		if config.TokenURL != "" {
+			subjectToken = clientCredentials(config, tokenClient)
+		}
+		return &tokenSource{fetch: func(ctx context.Context) (string, time.Time, error) {
+			token, _, err := subjectToken(ctx)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			exchangeParams := gravitee_auth.NewTokenExchangeParams()
+			exchangeParams.WithDefaults()
+			exchangeParams.SetIdentity(config.TokenExchangeIdentity)
+			exchangeParams.SetToken(&token)
+			exchangeParams.SetOrgID(config.Organization)
+			exchangeParams.SetEnvID(config.Environment)
+			exchangeParams.SetTimeout(time.Second * time.Duration(callTimeout(config)))
+			exchangeParams.SetContext(ctx)
+			resp, err := client_auth.TokenExchange(exchangeParams, nil)
+			if err != nil {
+				return "", time.Time{}, fmt.Errorf("unable to exchange token with identity provider %s: %w", config.TokenExchangeIdentity, err)
+			}
+			return resp.Payload.Token, jwtExpiry(resp.Payload.Token), nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown auth_mode %q", config.AuthMode)
+}
+
+// clientCredentials obtains an access token from the OAuth2 token endpoint with the
+// client credentials grant
+func clientCredentials(config *Config, httpClient *http.Client) func(ctx context.Context) (string, time.Time, error) {
+	return func(ctx context.Context) (string, time.Time, error) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if config.Scope != "" {
+			form.Set("scope", config.Scope)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("unable to reach token endpoint %s: %w", config.TokenURL, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", time.Time{}, fmt.Errorf("token endpoint %s answered %s", config.TokenURL, resp.Status)
+		}
+		tokenResponse := struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}{}
+		if err = json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+			return "", time.Time{}, fmt.Errorf("unable to read the token endpoint %s answer: %w", config.TokenURL, err)
+		}
+		if tokenResponse.AccessToken == "" {
+			return "", time.Time{}, fmt.Errorf("token endpoint %s returned no access token", config.TokenURL)
+		}
+		var expiry time.Time
+		if tokenResponse.ExpiresIn > 0 {
+			expiry = time.Now().Add(time.Second * time.Duration(tokenResponse.ExpiresIn))
+		}
+		return tokenResponse.AccessToken, expiry, nil
+	}
+}
+
+// tokenFile reads the token of a file, e.g. a projected service account token, which is
+// read again each time as it is renewed in place
+func tokenFile(fileName string) func(ctx context.Context) (string, time.Time, error) {
+	return func(ctx context.Context) (string, time.Time, error) {
+		token_raw, err := os.ReadFile(fileName)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("unable to read token file: %w", err)
+		}
+		token := strings.TrimSpace(string(token_raw))
+		return token, jwtExpiry(token), nil
+	}
+}
+
+// jwtExpiry returns the expiry of a JWT, the zero time if the token is not a JWT or does
+// not expire. The token is not verified, the management API does it.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestJWTExpiry(t *testing.T) {
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{"expiring", jwt(`{"sub":"operator","exp":1700000000}`), time.Unix(1700000000, 0)},
+		{"without expiry", jwt(`{"sub":"operator"}`), time.Time{}},
+		{"zero expiry", jwt(`{"exp":0}`), time.Time{}},
+		{"opaque token", "aaaaaaaa-bbbb-cccc-dddd-123456789012", time.Time{}},
+		{"two parts", "eyJhbGciOiJSUzI1NiJ9.eyJleHAiOjF9", time.Time{}},
+		{"payload not base64", "eyJhbGciOiJSUzI1NiJ9.!!!.c2lnbmF0dXJl", time.Time{}},
+		{"payload not JSON", jwt(`exp=1700000000`), time.Time{}},
+		{"expiry not a number", jwt(`{"exp":"tomorrow"}`), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jwtExpiry(tt.token); !got.Equal(tt.want) {
+				t.Errorf("jwtExpiry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ConfigEnvPrefix prefixes the environment variables overriding the configuration, e.g. GRAVITEE_OPERATOR_HOST
+const ConfigEnvPrefix = "GRAVITEE_OPERATOR_"
+
+// Config is the operator configuration. It is read from a YAML file or Secret, then each setting
+// can be overridden by an environment variable and by a command-line flag.
+type Config struct {
+	// management API scheme, http or https
+	Schemes string `yaml:"schemes"`
+
+	// management API host
+	Host string `yaml:"host"`
+
+	// management API base path
+	Path string `yaml:"path"`
+
+	// organization and environment IDs
+	Organization string `yaml:"organization"`
+	Environment  string `yaml:"environment"`
+
+	// credentials, either a user and a password or a token
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+
+	// authentication mode, empty to use the credentials above as they are, client_credentials
+	// to use an OAuth2 access token, token_exchange to exchange a token for a Gravitee one or
+	// login to log in with the user and password
+	AuthMode string `yaml:"auth_mode"`
+
+	// OAuth2 token endpoint, client and scope, for client_credentials and token_exchange, and
+	// CA bundle trusted for the token endpoint besides the system CAs, as a PEM file
+	TokenURL     string `yaml:"token_url"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	Scope        string `yaml:"scope"`
+	TokenCAFile  string `yaml:"token_ca_file"`
+
+	// Gravitee identity provider the tokens are exchanged with, and the file holding the token
+	// to exchange, e.g. a projected service account token, when there is no token endpoint
+	TokenExchangeIdentity string `yaml:"token_exchange_identity"`
+	TokenFile             string `yaml:"token_file"`
+
+	// CA bundle trusted for the management API, and client certificate and key for mutual TLS,
+	// as PEM files
+	CAFile         string `yaml:"ca_file"`
+	ClientCertFile string `yaml:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file"`
+
+	// Secret holding a CA bundle (ca.crt) and a client certificate and key (tls.crt and
+	// tls.key), as namespace/name, instead of or besides the files
+	TLSSecret string `yaml:"tls_secret"`
+
+	// minimum TLS version of the management API calls, 1.0 to 1.3
+	MinTLSVersion string `yaml:"min_tls_version"`
+
+	// HTTP proxy of the management API calls, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	// environment variables being used otherwise
+	Proxy string `yaml:"proxy"`
+
+	// timeout of the management API calls, in seconds
+	Timeout int `yaml:"timeout"`
+
+	// number of retries of the idempotent management API calls failed because of the
+	// management API, with a jittered exponential backoff
+	MaxRetries int `yaml:"max_retries"`
+
+	// number of consecutive failed management API calls opening the circuit breaker, which
+	// pauses the calls and the reconciliations for the open period, in seconds
+	BreakerThreshold  int `yaml:"breaker_threshold"`
+	BreakerOpenPeriod int `yaml:"breaker_open_period"`
+
+	// rate of the management API calls, per second, with bursts of up to rate_limit_burst
+	// calls, and number of calls in flight, zero meaning no limit
+	RateLimit      int `yaml:"rate_limit"`
+	RateLimitBurst int `yaml:"rate_limit_burst"`
+	MaxInFlight    int `yaml:"max_in_flight"`
+
+	// number of resources of each kind reconciled concurrently, read at startup
+	MaxConcurrentReconciles int `yaml:"max_concurrent_reconciles"`
+
+	// period of the reconciliation of the resources, in seconds
+	ReschedulePeriod int `yaml:"reschedule_period"`
+
+	// period of the checks for configuration changes, in seconds
+	ReloadPeriod int `yaml:"reload_period"`
+
+	// protocol and cluster domain of the targets given as Kubernetes Services
+	ServiceDefaultProtocol string `yaml:"service_default_protocol"`
+	ServiceDefaultDomain   string `yaml:"service_default_domain"`
+}
+
+// DefaultConfig returns the configuration defaults
+func DefaultConfig() *Config {
+	return &Config{
+		Schemes:                 "https",
+		Path:                    "/management",
+		Organization:            "DEFAULT",
+		Environment:             "DEFAULT",
+		Timeout:                 10,
+		MaxRetries:              3,
+		BreakerThreshold:        5,
+		BreakerOpenPeriod:       30,
+		RateLimit:               20,
+		RateLimitBurst:          40,
+		MaxInFlight:             10,
+		MaxConcurrentReconciles: 1,
+		ReschedulePeriod:        60,
+		ReloadPeriod:            10,
+		ServiceDefaultProtocol:  "http",
+		ServiceDefaultDomain:    "cluster.local",
+	}
+}
+
+// ParseConfig reads the configuration over the defaults, then applies the environment
+// variables and the overrides, keyed by setting name. Unknown settings and values of the
+// wrong type are rejected. All the problems found are reported in a single error.
+func ParseConfig(config_raw []byte, overrides map[string]string) (*Config, error) {
+	config := DefaultConfig()
+	errs := make([]error, 0)
+	if err := yaml.UnmarshalStrict(config_raw, config); err != nil {
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			return nil, fmt.Errorf("unable to read configuration: %w", err)
+		}
+		for _, message := range typeError.Errors {
+			errs = append(errs, errors.New(message))
+		}
+	}
+	for _, key := range ConfigKeys() {
+		if value, ok := os.LookupEnv(ConfigEnvPrefix + strings.ToUpper(key)); ok {
+			if err := config.Set(key, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for key, value := range overrides {
+		if err := config.Set(key, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return config, utilerrors.NewAggregate(errs)
+}
+
+// Validate checks the configuration, all the problems found are reported in a single error
+func (c *Config) Validate() error {
+	errs := make([]error, 0)
+	if c.Schemes != "http" && c.Schemes != "https" {
+		errs = append(errs, fmt.Errorf("schemes must be http or https, not %q", c.Schemes))
+	}
+	if c.Host == "" {
+		errs = append(errs, fmt.Errorf("host is required"))
+	}
+	if c.Organization == "" {
+		errs = append(errs, fmt.Errorf("organization is required"))
+	}
+	if c.Environment == "" {
+		errs = append(errs, fmt.Errorf("environment is required"))
+	}
+	if (c.User == "") != (c.Password == "") {
+		errs = append(errs, fmt.Errorf("user and password must be set together"))
+	}
+	switch c.AuthMode {
+	case "":
+	case AuthModeClientCredentials:
+		if c.TokenURL == "" || c.ClientID == "" {
+			errs = append(errs, fmt.Errorf("token_url and client_id are required by auth_mode %s", c.AuthMode))
+		}
+	case AuthModeTokenExchange:
+		if c.TokenExchangeIdentity == "" {
+			errs = append(errs, fmt.Errorf("token_exchange_identity is required by auth_mode %s", c.AuthMode))
+		}
+		if (c.TokenURL == "" || c.ClientID == "") && c.TokenFile == "" {
+			errs = append(errs, fmt.Errorf("token_url and client_id, or token_file, are required by auth_mode %s", c.AuthMode))
+		}
+	case AuthModeLogin:
+		if c.User == "" {
+			errs = append(errs, fmt.Errorf("user and password are required by auth_mode %s", c.AuthMode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("auth_mode must be %s, %s or %s, not %q", AuthModeClientCredentials, AuthModeTokenExchange, AuthModeLogin, c.AuthMode))
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		errs = append(errs, fmt.Errorf("client_cert_file and client_key_file must be set together"))
+	}
+	if c.TLSSecret != "" && !strings.Contains(c.TLSSecret, "/") {
+		errs = append(errs, fmt.Errorf("tls_secret must be namespace/name, not %q", c.TLSSecret))
+	}
+	if _, ok := tlsVersions[c.MinTLSVersion]; c.MinTLSVersion != "" && !ok {
+		errs = append(errs, fmt.Errorf("min_tls_version must be 1.0, 1.1, 1.2 or 1.3, not %q", c.MinTLSVersion))
+	}
+	if c.Proxy != "" {
+		if _, err := url.Parse(c.Proxy); err != nil {
+			errs = append(errs, fmt.Errorf("proxy must be a URL: %w", err))
+		}
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout must be positive, not %d", c.Timeout))
+	}
+	if c.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("max_retries must not be negative, not %d", c.MaxRetries))
+	}
+	if c.BreakerThreshold <= 0 {
+		errs = append(errs, fmt.Errorf("breaker_threshold must be positive, not %d", c.BreakerThreshold))
+	}
+	if c.BreakerOpenPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("breaker_open_period must be positive, not %d", c.BreakerOpenPeriod))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit must not be negative, not %d", c.RateLimit))
+	}
+	if c.RateLimitBurst < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit_burst must not be negative, not %d", c.RateLimitBurst))
+	}
+	if c.MaxInFlight < 0 {
+		errs = append(errs, fmt.Errorf("max_in_flight must not be negative, not %d", c.MaxInFlight))
+	}
+	if c.MaxConcurrentReconciles <= 0 {
+		errs = append(errs, fmt.Errorf("max_concurrent_reconciles must be positive, not %d", c.MaxConcurrentReconciles))
+	}
+	if c.ReschedulePeriod <= 0 {
+		errs = append(errs, fmt.Errorf("reschedule_period must be positive, not %d", c.ReschedulePeriod))
+	}
+	if c.ReloadPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("reload_period must be positive, not %d", c.ReloadPeriod))
+	}
+	if c.ServiceDefaultProtocol == "" {
+		errs = append(errs, fmt.Errorf("service_default_protocol is required"))
+	}
+	if c.ServiceDefaultDomain == "" {
+		errs = append(errs, fmt.Errorf("service_default_domain is required"))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ConfigKeys returns the names of the settings, as in the configuration file
+func ConfigKeys() []string {
+	keys := make([]string, 0)
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		keys = append(keys, configType.Field(i).Tag.Get("yaml"))
+	}
+	return keys
+}
+
+// Set sets a setting, by name, from its string value
+func (c *Config) Set(key string, value string) error {
+	configValue := reflect.ValueOf(c).Elem()
+	for i := 0; i < configValue.NumField(); i++ {
+		if configValue.Type().Field(i).Tag.Get("yaml") != key {
+			continue
+		}
+		field := configValue.Field(i)
+		switch field.Kind() {
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer, not %q", key, value)
+			}
+			field.SetInt(int64(n))
+		default:
+			field.SetString(value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+// BindConfigFlags registers a command-line flag for each setting, e.g. --gravitee-host, and
+// returns the overrides the flags set, to be given to NewConnections
+func BindConfigFlags(fs *flag.FlagSet) map[string]string {
+	overrides := make(map[string]string)
+	for _, key := range ConfigKeys() {
+		key := key
+		name := "gravitee-" + strings.ReplaceAll(key, "_", "-")
+		usage := fmt.Sprintf("Overrides the %s setting of the configuration file (env %s%s).", key, ConfigEnvPrefix, strings.ToUpper(key))
+		fs.Func(name, usage, func(value string) error {
+			overrides[key] = value
+			return nil
+		})
+	}
+	return overrides
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		overrides map[string]string
+		wantErrs  []string
+		check     func(*Config) bool
+	}{
+		{
+			name:   "defaults",
+			config: "host: apim.my.domain\ntoken: t\n",
+			check: func(c *Config) bool {
+				return c.Host == "apim.my.domain" && c.Schemes == "https" && c.Timeout == 10 && c.ReloadPeriod == 10
+			},
+		},
+		{
+			name:      "overrides win over the file",
+			config:    "host: apim.my.domain\ntimeout: 5\n",
+			overrides: map[string]string{"timeout": "20", "host": "other.my.domain"},
+			check: func(c *Config) bool {
+				return c.Host == "other.my.domain" && c.Timeout == 20
+			},
+		},
+		{
+			name:     "unknown setting",
+			config:   "host: apim.my.domain\nhots: typo\n",
+			wantErrs: []string{"field hots not found"},
+		},
+		{
+			name:     "wrong type",
+			config:   "host: apim.my.domain\ntimeout: ten\n",
+			wantErrs: []string{"cannot unmarshal !!str `ten` into int"},
+		},
+		{
+			name:      "all the problems reported",
+			config:    "timeout: ten\n",
+			overrides: map[string]string{"max_retries": "x"},
+			wantErrs:  []string{"cannot unmarshal", "max_retries must be an integer", "host is required"},
+		},
+		{
+			name:     "invalid YAML",
+			config:   "host: [",
+			wantErrs: []string{"unable to read configuration"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(tt.config), tt.overrides)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("ParseConfig() error = %v", err)
+				}
+				if !tt.check(config) {
+					t.Errorf("ParseConfig() = %+v", config)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ParseConfig() error = nil, want %v", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("ParseConfig() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		config := DefaultConfig()
+		config.Host = "apim.my.domain"
+		return config
+	}
+	tests := []struct {
+		name    string
+		change  func(*Config)
+		wantErr string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"scheme", func(c *Config) { c.Schemes = "ftp" }, "schemes must be http or https"},
+		{"host", func(c *Config) { c.Host = "" }, "host is required"},
+		{"user without password", func(c *Config) { c.User = "admin" }, "user and password must be set together"},
+		{"client credentials", func(c *Config) { c.AuthMode = AuthModeClientCredentials }, "token_url and client_id are required"},
+		{"token exchange with a token file", func(c *Config) {
+			c.AuthMode = AuthModeTokenExchange
+			c.TokenExchangeIdentity = "kubernetes"
+			c.TokenFile = "/var/run/secrets/token"
+		}, ""},
+		{"token exchange", func(c *Config) { c.AuthMode = AuthModeTokenExchange }, "token_exchange_identity is required"},
+		{"login", func(c *Config) { c.AuthMode = AuthModeLogin }, "user and password are required"},
+		{"auth mode", func(c *Config) { c.AuthMode = "basic" }, "auth_mode must be"},
+		{"client certificate without key", func(c *Config) { c.ClientCertFile = "tls.crt" }, "client_cert_file and client_key_file"},
+		{"tls secret", func(c *Config) { c.TLSSecret = "name" }, "tls_secret must be namespace/name"},
+		{"min tls version", func(c *Config) { c.MinTLSVersion = "1.4" }, "min_tls_version must be"},
+		{"timeout", func(c *Config) { c.Timeout = 0 }, "timeout must be positive"},
+		{"max retries", func(c *Config) { c.MaxRetries = -1 }, "max_retries must not be negative"},
+		{"no rate limit", func(c *Config) { c.RateLimit = 0 }, ""},
+		{"rate limit", func(c *Config) { c.RateLimit = -1 }, "rate_limit must not be negative"},
+		{"max concurrent reconciles", func(c *Config) { c.MaxConcurrentReconciles = 0 }, "max_concurrent_reconciles must be positive"},
+		{"reload period", func(c *Config) { c.ReloadPeriod = 0 }, "reload_period must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid()
+			tt.change(config)
+			err := config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr bool
+		check   func(*Config) bool
+	}{
+		{"host", "apim.my.domain", false, func(c *Config) bool { return c.Host == "apim.my.domain" }},
+		{"max_retries", "5", false, func(c *Config) bool { return c.MaxRetries == 5 }},
+		{"max_retries", "five", true, nil},
+		{"unknown", "value", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			config := DefaultConfig()
+			err := config.Set(tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(config) {
+				t.Errorf("Set() = %+v", config)
+			}
+		})
+	}
+}
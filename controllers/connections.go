@@ -0,0 +1,460 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	l "log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	types "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
+)
+
+//+kubebuilder:rbac:groups=platform.my.domain,resources=graviteeconnections,verbs=get;list;watch
+
+// DefaultConnectionName names the connection of the operator configuration, e.g. in the metrics
+const DefaultConnectionName = "default"
+
+var configReloads = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gravitee_operator_config_reloads_total",
+		Help: "Number of reloads of the operator configuration",
+	},
+	[]string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(configReloads)
+}
+
+// ConfigSource tells where the operator configuration is read from, the SecretKey of
+// the Secret when given, the File otherwise
+type ConfigSource struct {
+	File      string
+	Secret    *types.NamespacedName
+	SecretKey string
+}
+
+func (s ConfigSource) read(ctx context.Context, reader client.Reader) ([]byte, error) {
+	if s.Secret != nil {
+		secret := v1.Secret{}
+		if err := reader.Get(ctx, *s.Secret, &secret); err != nil {
+			return nil, fmt.Errorf("unable to load configuration: %w", err)
+		}
+		config_raw, ok := secret.Data[s.SecretKey]
+		if !ok {
+			return nil, fmt.Errorf("unable to load configuration: Secret %s has no key %s", s.Secret, s.SecretKey)
+		}
+		return config_raw, nil
+	}
+	if s.File == "" {
+		return nil, nil
+	}
+	config_raw, err := os.ReadFile(s.File)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load configuration: %w", err)
+	}
+	return config_raw, nil
+}
+
+// Connections is the management API client factory shared by the reconcilers. It provides
+// the APIController of each GraviteeConnection, the default one being configured by the
+// operator configuration. The APIControllers are cached and rebuilt when the connection
+// or its Secrets change, the errors being cached as well until then, and evicted when the
+// GraviteeConnection is deleted.
+//
+// Once added to the manager, Connections reloads the operator configuration when its
+// source changes and swaps the default APIController, the reconciles in progress keeping
+// the one they got.
+type Connections struct {
+	client.Client
+	reader    client.Reader
+	source    ConfigSource
+	overrides map[string]string
+
+	mu                sync.Mutex
+	config            *Config
+	version           string
+	rejected          string
+	defaultController *APIController
+	controllers       map[types.NamespacedName]*connection
+}
+
+// connection is a cached APIController, or the validation error building it, for a version of a
+// GraviteeConnection and its Secrets
+type connection struct {
+	version    string
+	controller *APIController
+	err        error
+}
+
+// NewConnections loads the operator configuration from the source, reading Secrets with
+// the reader as the manager cache is not started yet, and sets up the default APIController.
+// The reloads also check the TLS Secret of the configuration.
+func NewConnections(c client.Client, reader client.Reader, source ConfigSource, overrides map[string]string) (*Connections, error) {
+	connections := &Connections{
+		Client:      c,
+		reader:      reader,
+		source:      source,
+		overrides:   overrides,
+		controllers: make(map[types.NamespacedName]*connection),
+	}
+	if _, err := connections.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// load reads the configuration, the TLS Secret and the files it references and, when they
+// changed since the last load, sets up the default APIController. It tells whether they changed.
+func (c *Connections) load(ctx context.Context) (bool, error) {
+	config_raw, err := c.source.read(ctx, c.reader)
+	if err != nil {
+		return false, err
+	}
+	version := string(config_raw)
+	config, err := ParseConfig(config_raw, c.overrides)
+	var tlsSecret *v1.Secret
+	if err == nil {
+		if tlsSecret, err = readTLSSecret(ctx, c.reader, config.TLSSecret); err != nil {
+			return false, err
+		}
+		if tlsSecret != nil {
+			version += "/" + tlsSecret.ResourceVersion
+		}
+		version += filesVersion(config)
+	}
+	c.mu.Lock()
+	unchanged := version == c.version || version == c.rejected
+	c.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+	controller := &APIController{Name: DefaultConnectionName}
+	if err == nil {
+		err = controller.Init(config, tlsSecret)
+	}
+	if err == nil {
+		err = controller.ValidateEnvironment(ctx)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		// an invalid configuration is not loaded again until it or the contents it references
+		// change, while a transient failure, e.g. a file not mounted yet, is retried
+		var managementError *ManagementError
+		if !errors.As(err, &managementError) || managementError.Class != ErrorTransient {
+			c.rejected = version
+		}
+		return false, err
+	}
+	c.config = config
+	c.version = version
+	c.defaultController = controller
+	return true, nil
+}
+
+// filesVersion returns the hashes of the contents of the files the configuration references,
+// so that a rotated certificate or token file is reloaded like a changed configuration
+func filesVersion(config *Config) string {
+	version := ""
+	for _, fileName := range []string{config.CAFile, config.ClientCertFile, config.ClientKeyFile, config.TokenFile, config.TokenCAFile} {
+		if fileName == "" {
+			continue
+		}
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			// the error is reported when the APIController is set up
+			version += "/" + fileName + ":unreadable"
+			continue
+		}
+		version += fmt.Sprintf("/%s:%x", fileName, sha256.Sum256(content))
+	}
+	return version
+}
+
+// Config returns the current operator configuration
+func (c *Connections) Config() *Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// Default returns the APIController configured by the current operator configuration
+func (c *Connections) Default() *APIController {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.defaultController
+}
+
+// Start checks the configuration source every reload_period, and the health of the
+// connections every healthCheckPeriod, until the context is done
+func (c *Connections) Start(ctx context.Context) error {
+	go c.checkHealth(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(c.Config().ReloadPeriod) * time.Second):
+			c.reload(ctx)
+			c.prune(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection tells the manager to reload the configuration on every replica
+func (c *Connections) NeedLeaderElection() bool {
+	return false
+}
+
+func (c *Connections) reload(ctx context.Context) {
+	changed, err := c.load(ctx)
+	if err != nil {
+		l.Printf("unable to reload the configuration, keeping the current one: %s", err)
+		configReloads.WithLabelValues("failure").Inc()
+		return
+	}
+	if changed {
+		l.Printf("configuration reloaded")
+		configReloads.WithLabelValues("success").Inc()
+	}
+}
+
+// prune evicts the cached APIControllers of the deleted GraviteeConnections
+func (c *Connections) prune(ctx context.Context) {
+	graviteeConnections := platformv1beta1.GraviteeConnectionList{}
+	if err := c.Client.List(ctx, &graviteeConnections); err != nil {
+		l.Printf("unable to list GraviteeConnections: %s", err)
+		return
+	}
+	existing := make(map[types.NamespacedName]bool)
+	for _, graviteeConnection := range graviteeConnections.Items {
+		existing[types.NamespacedName{Name: graviteeConnection.Name, Namespace: graviteeConnection.Namespace}] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for namespacedName := range c.controllers {
+		if !existing[namespacedName] {
+			c.evict(namespacedName)
+		}
+	}
+}
+
+// evict removes the cached APIController of a GraviteeConnection and its metrics, the lock
+// being held
+func (c *Connections) evict(namespacedName types.NamespacedName) {
+	if _, ok := c.controllers[namespacedName]; !ok {
+		return
+	}
+	delete(c.controllers, namespacedName)
+	circuitBreakerState.DeleteLabelValues(namespacedName.String())
+	connectionUp.DeleteLabelValues(namespacedName.String())
+	l.Printf("disconnected from GraviteeConnection %s", namespacedName)
+}
+
+// checkHealth checks the health of the connections every healthCheckPeriod until the
+// context is done, each check being bounded by healthCheckTimeout
+func (c *Connections) checkHealth(ctx context.Context) {
+	for {
+		for _, controller := range c.all() {
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			controller.CheckHealth(checkCtx)
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthCheckPeriod):
+		}
+	}
+}
+
+// all returns the default APIController and the cached ones of the GraviteeConnections
+func (c *Connections) all() []*APIController {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	controllers := []*APIController{c.defaultController}
+	for _, cached := range c.controllers {
+		if cached.controller != nil {
+			controllers = append(controllers, cached.controller)
+		}
+	}
+	return controllers
+}
+
+// CircuitBreakersCheck is a readiness check failing while the circuit breaker of the default
+// connection is open. The GraviteeConnections, created by the users, do not gate the
+// readiness, their state being exported as metrics.
+func (c *Connections) CircuitBreakersCheck(_ *http.Request) error {
+	if wait := c.Default().Unavailable(); wait > 0 {
+		return fmt.Errorf("management API unavailable for connection %s, retrying in %s", DefaultConnectionName, wait)
+	}
+	return nil
+}
+
+// HealthCheck is a readiness check failing while the default connection can not be called
+// as the operator, according to the last background health check
+func (c *Connections) HealthCheck(_ *http.Request) error {
+	if err := c.Default().Health(); err != nil {
+		return fmt.Errorf("management API unhealthy for connection %s: %w", DefaultConnectionName, err)
+	}
+	return nil
+}
+
+// Get returns the APIController of the referenced GraviteeConnection, the namespace of the
+// reference defaulting to the given one, or the default APIController when ref is nil.
+// A GraviteeConnection of another namespace must list the given one in its allowed namespaces.
+func (c *Connections) Get(ctx context.Context, ref *platformv1beta1.ConnectionReference, namespace string) (*APIController, error) {
+	if ref == nil {
+		return c.Default(), nil
+	}
+	namespacedName := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+	if namespacedName.Namespace == "" {
+		namespacedName.Namespace = namespace
+	}
+	graviteeConnection := platformv1beta1.GraviteeConnection{}
+	if err := c.Client.Get(ctx, namespacedName, &graviteeConnection); err != nil {
+		l.Printf("unable to get GraviteeConnection %s: %s", namespacedName, err)
+		if apierrors.IsNotFound(err) {
+			c.mu.Lock()
+			c.evict(namespacedName)
+			c.mu.Unlock()
+		}
+		return nil, err
+	}
+	if namespacedName.Namespace != namespace && !containsString(graviteeConnection.Spec.AllowedNamespaces, namespace) {
+		return nil, newManagementError(ErrorValidation, "GraviteeConnection %s does not allow the namespace %s", namespacedName, namespace)
+	}
+	version := graviteeConnection.ResourceVersion
+	var secret *v1.Secret
+	if ref := graviteeConnection.Spec.CredentialsSecretRef; ref != nil {
+		secret = &v1.Secret{}
+		if err := c.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespacedName.Namespace}, secret); err != nil {
+			l.Printf("unable to get the credentials of GraviteeConnection %s: %s", namespacedName, err)
+			return nil, err
+		}
+		version += "/" + secret.ResourceVersion
+	}
+	var tlsSecret *v1.Secret
+	if ref := graviteeConnection.Spec.TLSSecretRef; ref != nil {
+		tlsSecret = &v1.Secret{}
+		if err := c.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespacedName.Namespace}, tlsSecret); err != nil {
+			l.Printf("unable to get the TLS Secret of GraviteeConnection %s: %s", namespacedName, err)
+			return nil, err
+		}
+		version += "/" + tlsSecret.ResourceVersion
+	}
+
+	c.mu.Lock()
+	cached, ok := c.controllers[namespacedName]
+	c.mu.Unlock()
+	if ok && cached.version == version {
+		return cached.controller, cached.err
+	}
+	// the controller is built without holding the lock, as it calls the management API
+	controller, err := newConnectionController(ctx, &graviteeConnection, secret, tlsSecret)
+	if err != nil && ErrorClassOf(err) != ErrorValidation {
+		// not cached, the connection is built again by the next reconcile
+		l.Printf("unable to connect to GraviteeConnection %s: %s", namespacedName, err)
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.controllers[namespacedName] = &connection{version: version, controller: controller, err: err}
+	if err != nil {
+		l.Printf("invalid GraviteeConnection %s: %s", namespacedName, err)
+		return nil, err
+	}
+	l.Printf("connected to GraviteeConnection %s", namespacedName)
+	return controller, nil
+}
+
+// newConnectionController builds the APIController of a GraviteeConnection, returning a
+// validation error when the connection is invalid
+func newConnectionController(ctx context.Context, graviteeConnection *platformv1beta1.GraviteeConnection, secret *v1.Secret, tlsSecret *v1.Secret) (*APIController, error) {
+	spec := graviteeConnection.Spec
+	config := DefaultConfig()
+	config.Host = spec.Host
+	if spec.Path != "" {
+		config.Path = spec.Path
+	}
+	if spec.Scheme != "" {
+		config.Schemes = spec.Scheme
+	}
+	if spec.Organization != "" {
+		config.Organization = spec.Organization
+	}
+	if spec.Environment != "" {
+		config.Environment = spec.Environment
+	}
+	if spec.Timeout != 0 {
+		config.Timeout = spec.Timeout
+	}
+	config.AuthMode = spec.AuthMode
+	config.TokenURL = spec.TokenURL
+	config.Scope = spec.Scope
+	config.TokenExchangeIdentity = spec.TokenExchangeIdentity
+	config.MinTLSVersion = spec.MinTLSVersion
+	if spec.RateLimit != nil {
+		config.RateLimit = *spec.RateLimit
+	}
+	if spec.RateLimitBurst != nil {
+		config.RateLimitBurst = *spec.RateLimitBurst
+	}
+	if spec.MaxInFlight != nil {
+		config.MaxInFlight = *spec.MaxInFlight
+	}
+	config.Proxy = spec.Proxy
+	if secret != nil {
+		config.User = string(secret.Data["user"])
+		config.Password = string(secret.Data["password"])
+		config.Token = string(secret.Data["token"])
+		config.ClientID = string(secret.Data["client_id"])
+		config.ClientSecret = string(secret.Data["client_secret"])
+		if config.AuthMode == "" && (config.User == "" || config.Password == "") && config.Token == "" {
+			return nil, newManagementError(ErrorValidation, "Secret %s holds neither a token nor a user and a password", secret.Name)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, newManagementError(ErrorValidation, "invalid GraviteeConnection %s: %w", graviteeConnection.Name, err)
+	}
+	controller := &APIController{Name: graviteeConnection.Namespace + "/" + graviteeConnection.Name}
+	if err := controller.Init(config, tlsSecret); err != nil {
+		// the classified errors are transient, e.g. an unreadable file, the others invalid settings
+		var managementError *ManagementError
+		if errors.As(err, &managementError) {
+			return nil, classify(err, "unable to set up GraviteeConnection %s", graviteeConnection.Name)
+		}
+		return nil, newManagementError(ErrorValidation, "invalid GraviteeConnection %s: %w", graviteeConnection.Name, err)
+	}
+	if err := controller.ValidateEnvironment(ctx); err != nil {
+		return nil, classify(err, "invalid GraviteeConnection %s", graviteeConnection.Name)
+	}
+	return controller, nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	httpruntime "github.com/go-openapi/runtime"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// ErrorClass tells how a reconcile recovers from a management API error
+type ErrorClass string
+
+const (
+	// the management API is unavailable, the call is retried with backoff
+	ErrorTransient ErrorClass = "Transient"
+	// the call conflicts with the management API state, it is retried after the reschedule period
+	ErrorConflict ErrorClass = "Conflict"
+	// the object is missing from the management API, it is retried after the reschedule period
+	ErrorNotFound ErrorClass = "NotFound"
+	// the management API rejected the resource, it is retried once the resource changes
+	ErrorValidation ErrorClass = "Validation"
+)
+
+// ConditionSynced is the condition type telling whether a resource is in sync with the management API
+const ConditionSynced = "Synced"
+
+// ManagementError is a classified management API error
+type ManagementError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ManagementError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ManagementError) Unwrap() error {
+	return e.Err
+}
+
+// newManagementError returns an error of the given class
+func newManagementError(class ErrorClass, format string, a ...interface{}) error {
+	return &ManagementError{Class: class, Err: fmt.Errorf(format, a...)}
+}
+
+// classify wraps a management API call error with its class, keeping its message
+func classify(err error, format string, a ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &ManagementError{Class: ErrorClassOf(err), Err: fmt.Errorf(format+": %w", append(a, err)...)}
+}
+
+// the package of the generated management API client, whose typed error responses are
+// named after their status, e.g. GetAPIPlanInternalServerError
+const generatedClientPackage = "my.domain/platform/gk8soperator/pkg/gravitee/client/"
+
+// the status codes of the generated typed error responses, by type name suffix
+var responseStatusCodes = []struct {
+	suffix string
+	code   int
+}{
+	{"BadRequest", http.StatusBadRequest},
+	{"Unauthorized", http.StatusUnauthorized},
+	{"Forbidden", http.StatusForbidden},
+	{"NotFound", http.StatusNotFound},
+	{"Conflict", http.StatusConflict},
+	{"UnprocessableEntity", http.StatusUnprocessableEntity},
+	{"TooManyRequests", http.StatusTooManyRequests},
+	{"InternalServerError", http.StatusInternalServerError},
+	{"ServiceUnavailable", http.StatusServiceUnavailable},
+}
+
+// statusCodeOf returns the status code of the management API response an error was built
+// from, zero when there is no response
+func statusCodeOf(err error) int {
+	// undocumented statuses
+	var apiError *httpruntime.APIError
+	if errors.As(err, &apiError) {
+		return apiError.Code
+	}
+	// default responses
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	// documented statuses
+	for ; err != nil; err = errors.Unwrap(err) {
+		errType := reflect.TypeOf(err)
+		if errType.Kind() == reflect.Ptr {
+			errType = errType.Elem()
+		}
+		if !strings.HasPrefix(errType.PkgPath(), generatedClientPackage) {
+			continue
+		}
+		for _, status := range responseStatusCodes {
+			if strings.HasSuffix(errType.Name(), status.suffix) {
+				return status.code
+			}
+		}
+	}
+	return 0
+}
+
+// ErrorClassOf returns the class of an error, from the status code of the management API
+// response when there is one. Errors without a response, e.g. timeouts, are transient.
+func ErrorClassOf(err error) ErrorClass {
+	var managementError *ManagementError
+	if errors.As(err, &managementError) {
+		return managementError.Class
+	}
+	code := statusCodeOf(err)
+	switch {
+	case code == http.StatusNotFound:
+		return ErrorNotFound
+	case code == http.StatusConflict:
+		return ErrorConflict
+	case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+		return ErrorValidation
+	default:
+		// 5xx, 401 and 403 until the credentials are fixed, 429, and no response at all
+		return ErrorTransient
+	}
+}
+
+// IsNotFound tells whether the error is about an object missing from the management API
+func IsNotFound(err error) bool {
+	return err != nil && ErrorClassOf(err) == ErrorNotFound
+}
+
+// managementResult returns the result of a reconcile that failed on a management API error,
+// according to its class
+func managementResult(err error, reschedulePeriod int) (ctrl.Result, error) {
+	switch ErrorClassOf(err) {
+	case ErrorValidation:
+		return ctrl.Result{}, nil
+	case ErrorConflict, ErrorNotFound:
+		return ctrl.Result{RequeueAfter: time.Duration(reschedulePeriod) * time.Second}, nil
+	default:
+		return ctrl.Result{}, err
+	}
+}
+
+// setSyncedCondition sets the Synced condition, false with the error class as reason when
+// the reconcile failed
+func setSyncedCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               ConditionSynced,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		ObservedGeneration: generation,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = string(ErrorClassOf(err)) + "Error"
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
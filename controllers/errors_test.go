@@ -0,0 +1,67 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	httpruntime "github.com/go-openapi/runtime"
+
+	gravitee_apis "my.domain/platform/gk8soperator/pkg/gravitee/client/a_p_is"
+	gravitee_plans "my.domain/platform/gk8soperator/pkg/gravitee/client/api_plans"
+	gravitee_api_subs "my.domain/platform/gk8soperator/pkg/gravitee/client/api_subscriptions"
+	gravitee_notifications "my.domain/platform/gk8soperator/pkg/gravitee/client/portal_notifications"
+)
+
+// notFoundError is named like a generated error response, outside of the generated client
+type notFoundError struct{}
+
+func (e *notFoundError) Error() string { return "not found" }
+
+func TestErrorClassOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"management error", newManagementError(ErrorConflict, "plan %s still has subscriptions", "gold"), ErrorConflict},
+		{"wrapped management error", fmt.Errorf("reconcile: %w", newManagementError(ErrorValidation, "invalid")), ErrorValidation},
+		{"classified response", classify(&gravitee_api_subs.ChangeAPISubscriptionStatusNotFound{}, "unable to change subscription %s", "sub-1"), ErrorNotFound},
+		{"not found response", &gravitee_api_subs.ChangeAPISubscriptionStatusNotFound{}, ErrorNotFound},
+		{"bad request response", &gravitee_plans.UpdateAPIPlanBadRequest{}, ErrorValidation},
+		{"forbidden response", &gravitee_apis.UpdateWithDefinitionForbidden{}, ErrorTransient},
+		{"internal server error response", &gravitee_plans.GetAPIPlanInternalServerError{}, ErrorTransient},
+		{"default response", gravitee_notifications.NewDeleteNotificationSettingsDefault(409), ErrorConflict},
+		{"undocumented 404", httpruntime.NewAPIError("unknown response", nil, 404), ErrorNotFound},
+		{"undocumented 422", httpruntime.NewAPIError("unknown response", nil, 422), ErrorValidation},
+		{"undocumented 429", httpruntime.NewAPIError("unknown response", nil, 429), ErrorTransient},
+		{"wrapped undocumented 409", fmt.Errorf("call failed: %w", httpruntime.NewAPIError("unknown response", nil, 409)), ErrorConflict},
+		{"status code in the message only", errors.New("[GET /apis/{api}][404] getApiNotFound"), ErrorTransient},
+		{"named like a response", &notFoundError{}, ErrorTransient},
+		{"timeout", context.DeadlineExceeded, ErrorTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorClassOf(tt.err); got != tt.want {
+				t.Errorf("ErrorClassOf() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
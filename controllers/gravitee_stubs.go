@@ -3,70 +3,114 @@ package controllers
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	l "log"
-	"os"
+	"sort"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
 	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
 	gravitee_apis "my.domain/platform/gk8soperator/pkg/gravitee/client/a_p_is"
 	gravitee_analytics "my.domain/platform/gk8soperator/pkg/gravitee/client/api_analytics"
+	gravitee_metadata "my.domain/platform/gk8soperator/pkg/gravitee/client/api_metadata"
+	gravitee_pages "my.domain/platform/gk8soperator/pkg/gravitee/client/api_pages"
 	gravitee_plans "my.domain/platform/gk8soperator/pkg/gravitee/client/api_plans"
+	gravitee_api_subs "my.domain/platform/gk8soperator/pkg/gravitee/client/api_subscriptions"
 	gravitee_subs "my.domain/platform/gk8soperator/pkg/gravitee/client/application_subscriptions"
 	gravitee_apps "my.domain/platform/gk8soperator/pkg/gravitee/client/applications"
+	gravitee_categories "my.domain/platform/gk8soperator/pkg/gravitee/client/categories"
+	gravitee_user "my.domain/platform/gk8soperator/pkg/gravitee/client/current_user"
+	gravitee_env "my.domain/platform/gk8soperator/pkg/gravitee/client/environment"
+	gravitee_groups "my.domain/platform/gk8soperator/pkg/gravitee/client/groups"
 	gravitee_models "my.domain/platform/gk8soperator/pkg/gravitee/models"
 	log "sigs.k8s.io/controller-runtime/pkg/log"
 
 	httpruntime "github.com/go-openapi/runtime"
 	httptransport "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
-	yaml "gopkg.in/yaml.v2"
 )
 
+// ForcePlanRemovalAnnotation allows removing plans that still have active subscriptions
+const ForcePlanRemovalAnnotation = "apiendpoint.platform.my.domain/force-plan-removal"
+
 type APIController struct {
-	config           map[interface{}]interface{}
-	authInfo         httpruntime.ClientAuthInfoWriter
-	client_apps      gravitee_apps.ClientService
-	client_apis      gravitee_apis.ClientService
-	client_plans     gravitee_plans.ClientService
-	client_subs      gravitee_subs.ClientService
-	client_analytics gravitee_analytics.ClientService
-	Timeout          int
-	OrgID            string
-	EnvID            string
+	Name              string
+	config            *Config
+	breaker           *circuitBreaker
+	health            *healthStatus
+	authInfo          httpruntime.ClientAuthInfoWriter
+	client_apps       gravitee_apps.ClientService
+	client_apis       gravitee_apis.ClientService
+	client_plans      gravitee_plans.ClientService
+	client_subs       gravitee_subs.ClientService
+	client_api_subs   gravitee_api_subs.ClientService
+	client_analytics  gravitee_analytics.ClientService
+	client_pages      gravitee_pages.ClientService
+	client_metadata   gravitee_metadata.ClientService
+	client_categories gravitee_categories.ClientService
+	client_groups     gravitee_groups.ClientService
+	client_user       gravitee_user.ClientService
+	client_env        gravitee_env.ClientService
+	Timeout           int
+	OrgID             string
+	EnvID             string
 }
 
-func (c *APIController) Init() error {
-	c.config = make(map[interface{}]interface{})
-	config_file_name := os.Getenv("GRAVITEE_OPERATOR_CONFIG_FILE")
-	config_raw, err := os.ReadFile(config_file_name)
+// Init sets up the management API clients from the operator configuration and the TLS
+// Secret it references, if any
+func (c *APIController) Init(config *Config, tlsSecret *v1.Secret) error {
+	httpTransport, err := newHTTPTransport(config, tlsSecret)
 	if err != nil {
-		l.Panicf("unable to load configuration %v", err)
+		return err
 	}
-	err = yaml.Unmarshal(config_raw, &c.config)
-	if err != nil {
-		l.Panicf("unable to read configuration %v", err)
+	c.breaker = newCircuitBreaker(c.Name, config.BreakerThreshold, time.Second*time.Duration(config.BreakerOpenPeriod))
+	transport := httptransport.New(config.Host, config.Path, []string{config.Schemes})
+	limitedTransport := newLimitedTransport(httpTransport, config.RateLimit, config.RateLimitBurst, config.MaxInFlight)
+	transport.Transport = &resilientTransport{
+		next:           limitedTransport,
+		breaker:        c.breaker,
+		maxRetries:     config.MaxRetries,
+		attemptTimeout: time.Second * time.Duration(config.Timeout),
 	}
-
-	var schemes []string
-	schemes = append(schemes, c.config["schemes"].(string))
-	transport := httptransport.New(c.config["host"].(string), c.config["path"].(string), schemes)
-	if c.config["user"] != nil && len(c.config["user"].(string)) > 0 && c.config["password"] != nil && len(c.config["password"].(string)) > 0 {
-		c.authInfo = httptransport.BasicAuth(c.config["user"].(string), c.config["password"].(string))
-	} else if c.config["token"] != nil && len(c.config["token"].(string)) > 0 {
-		c.authInfo = httptransport.BearerToken(c.config["token"].(string))
+	var authInfo httpruntime.ClientAuthInfoWriter
+	switch {
+	case config.AuthMode != "":
+		source, err := newTokenSource(config, transport.Transport)
+		if err != nil {
+			return err
+		}
+		transport.Transport = &tokenTransport{next: transport.Transport, source: source}
+	case config.User != "" && config.Password != "":
+		authInfo = httptransport.BasicAuth(config.User, config.Password)
+	case config.Token != "":
+		authInfo = httptransport.BearerToken(config.Token)
 	}
-	c.Timeout = c.config["timeout"].(int)
-	c.OrgID = c.config["organization"].(string)
-	c.EnvID = c.config["environment"].(string)
+	c.config = config
+	c.authInfo = authInfo
+	c.Timeout = callTimeout(config)
+	c.OrgID = config.Organization
+	c.EnvID = config.Environment
 	c.client_apis = gravitee_apis.New(transport, strfmt.Default)
 	c.client_apps = gravitee_apps.New(transport, strfmt.Default)
 	c.client_plans = gravitee_plans.New(transport, strfmt.Default)
 	c.client_subs = gravitee_subs.New(transport, strfmt.Default)
+	c.client_api_subs = gravitee_api_subs.New(transport, strfmt.Default)
 	c.client_analytics = gravitee_analytics.New(transport, strfmt.Default)
+	c.client_pages = gravitee_pages.New(transport, strfmt.Default)
+	c.client_metadata = gravitee_metadata.New(transport, strfmt.Default)
+	c.client_categories = gravitee_categories.New(transport, strfmt.Default)
+	c.client_groups = gravitee_groups.New(transport, strfmt.Default)
+	c.client_user = gravitee_user.New(transport, strfmt.Default)
+	c.client_env = gravitee_env.New(transport, strfmt.Default)
+	c.health = &healthStatus{}
 	return nil
 }
 
+// Unavailable returns how long the management API is considered down, zero when it can be called
+func (c *APIController) Unavailable() time.Duration {
+	return c.breaker.Wait()
+}
+
 func (c *APIController) GetAPI(APIID string) (*gravitee_models.APIEntity, error) {
 	getAPIParams := gravitee_apis.GetAPIParams{}
 	getAPIParams.WithDefaults()
@@ -85,10 +129,42 @@ func (c *APIController) GetAPI(APIID string) (*gravitee_models.APIEntity, error)
 	return api.Payload, err
 }
 
+// apiVirtualHosts returns the virtual hosts of the API, the context path being a single virtual host
+func apiVirtualHosts(apiEndpoint *platformv1beta1.APIEndpoint) []*gravitee_models.VirtualHost {
+	virtualHosts := make([]*gravitee_models.VirtualHost, 0)
+	if len(apiEndpoint.Spec.VirtualHosts) == 0 {
+		virtualHosts = append(virtualHosts, &gravitee_models.VirtualHost{Path: apiEndpoint.Spec.ContextPath})
+	}
+	for _, virtualHost := range apiEndpoint.Spec.VirtualHosts {
+		virtualHosts = append(virtualHosts, &gravitee_models.VirtualHost{
+			Host:               virtualHost.Host,
+			Path:               virtualHost.Path,
+			OverrideEntrypoint: virtualHost.OverrideEntrypoint,
+		})
+	}
+	return virtualHosts
+}
+
+// apiPaths returns the context paths of an API, one per virtual host
+func apiPaths(api *gravitee_models.APIEntity) []string {
+	paths := make([]string, 0)
+	if api.Proxy != nil {
+		for _, virtualHost := range api.Proxy.VirtualHosts {
+			paths = append(paths, virtualHost.Path)
+		}
+	}
+	if len(paths) == 0 {
+		paths = append(paths, api.ContextPath)
+	}
+	return paths
+}
+
 func (c *APIController) CreateAPI(apiEndpoint *platformv1beta1.APIEndpoint) (*gravitee_apis.CreateAPICreated, error) {
+	// the API is created on its first path, the virtual hosts are set by the update
+	contextPath := apiVirtualHosts(apiEndpoint)[0].Path
 	createAPIParams := gravitee_apis.CreateAPIParams{}
 	createAPIParams.WithAPI(&gravitee_models.NewAPIEntity{
-		ContextPath: &apiEndpoint.Spec.ContextPath,
+		ContextPath: &contextPath,
 		Description: &apiEndpoint.Spec.Description,
 		Name:        &apiEndpoint.Spec.Name,
 		Version:     &apiEndpoint.Spec.Version,
@@ -123,18 +199,32 @@ func (c *APIController) SearchAPI(ContextPath string) (*gravitee_models.APIListI
 	// l.Printf("SearchApisParams: %s", json_params)
 	apis, err := c.client_apis.SearchApis(&searchApisParams, c.authInfo)
 	if err != nil {
-		l.Panicf("unable to search APIs %s", err)
-		return nil, err
+		l.Printf("unable to search APIs %s", err)
+		return nil, classify(err, "unable to search APIs on the context path %s", ContextPath)
 	}
 	l.Printf("searchAPIsResults: %v", apis.Payload)
-	if len(apis.Payload) == 1 {
-		return apis.Payload[0], nil
-	} else {
-		return nil, nil
+	// the search is not exact, keep the APIs having a virtual host on the context path
+	var found *gravitee_models.APIListItem
+	for _, api := range apis.Payload {
+		matches := api.ContextPath == ContextPath
+		for _, virtualHost := range api.VirtualHosts {
+			matches = matches || virtualHost.Path == ContextPath
+		}
+		if !matches {
+			continue
+		}
+		if found != nil && found.ID != api.ID {
+			return nil, newManagementError(ErrorConflict, "several APIs are exposed on the context path %s", ContextPath)
+		}
+		found = api
 	}
+	if found == nil {
+		return nil, newManagementError(ErrorNotFound, "no API exposed on the context path %s", ContextPath)
+	}
+	return found, nil
 }
 
-func (c *APIController) UpdateAPI(apiEndpoint *platformv1beta1.APIEndpoint, target *string, ctx context.Context) error {
+func (c *APIController) UpdateAPI(apiEndpoint *platformv1beta1.APIEndpoint, target *string, resolvedValues *ResolvedValues, ctx context.Context) error {
 	updateAPIParams := gravitee_apis.UpdateAPIParams{}
 	updateAPIParams.WithDefaults()
 	updateAPIParams.SetPathAPI(apiEndpoint.Status.ID)
@@ -142,21 +232,55 @@ func (c *APIController) UpdateAPI(apiEndpoint *platformv1beta1.APIEndpoint, targ
 	updateAPIEntity.Name = &apiEndpoint.Spec.Name
 	updateAPIEntity.Version = &apiEndpoint.Spec.Version
 	updateAPIEntity.Description = &apiEndpoint.Spec.Description
-	updateAPIEntity.Categories = make([]string, 0)
+	categories, err := c.GetCategoryIDs(apiEndpoint.Spec.Categories)
+	if err != nil {
+		return err
+	}
+	updateAPIEntity.Categories = categories
 	updateAPIEntity.Flows = make([]*gravitee_models.Flow, 0)
-	updateAPIEntity.Groups = make([]string, 0)
-	updateAPIEntity.Labels = make([]string, 0)
-	updateAPIEntity.Metadata = make([]*gravitee_models.APIMetadataEntity, 0)
+	groups, err := c.GetGroupIDs(apiEndpoint.Spec.Groups)
+	if err != nil {
+		return err
+	}
+	updateAPIEntity.Groups = groups
+	updateAPIEntity.Labels = apiLabels(apiEndpoint)
+	// the metadata are managed apart, the update keeps the existing ones
+	metadata, err := c.UpdateAPIMetadata(apiEndpoint)
+	if err != nil {
+		return err
+	}
+	updateAPIEntity.Metadata = metadata
 	updateAPIEntity.PathMappings = make([]string, 0)
+	if apiEndpoint.Spec.PathMappings != nil {
+		updateAPIEntity.PathMappings = apiEndpoint.Spec.PathMappings
+	}
 	updateAPIEntity.Properties = make([]*gravitee_models.PropertyEntity, 0)
+	for _, property := range apiEndpoint.Spec.Properties {
+		value := resolvedValues.Properties[property.Key]
+		// Secret values are encrypted by Gravitee when saved
+		encryptable := property.ValueFrom != nil && property.ValueFrom.SecretKeyRef != nil
+		updateAPIEntity.Properties = append(updateAPIEntity.Properties, &gravitee_models.PropertyEntity{
+			Key:         property.Key,
+			Value:       &value,
+			Encryptable: &encryptable,
+		})
+	}
 	updateAPIEntity.Resources = make([]*gravitee_models.Resource, 0)
+	for _, resource := range apiEndpoint.Spec.Resources {
+		configuration := resolvedValues.Resources[resource.Name]
+		enabled := resource.Enabled == nil || *resource.Enabled
+		updateAPIEntity.Resources = append(updateAPIEntity.Resources, &gravitee_models.Resource{
+			Name:          &resource.Name,
+			Type:          &resource.Type,
+			Enabled:       enabled,
+			Configuration: &configuration,
+		})
+	}
 	var PRIVATE = string("private")
 	updateAPIEntity.Visibility = &PRIVATE
 	updateAPIEntity.Tags = apiEndpoint.Spec.Tags
 	updateAPIEntity.Proxy = &gravitee_models.Proxy{}
-	updateAPIEntity.Proxy.VirtualHosts = make([]*gravitee_models.VirtualHost, 1)
-	updateAPIEntity.Proxy.VirtualHosts[0] = &gravitee_models.VirtualHost{}
-	updateAPIEntity.Proxy.VirtualHosts[0].Path = apiEndpoint.Spec.ContextPath
+	updateAPIEntity.Proxy.VirtualHosts = apiVirtualHosts(apiEndpoint)
 	updateAPIEntity.Proxy.Groups = make([]*gravitee_models.EndpointGroup, 1)
 	updateAPIEntity.Proxy.Groups[0] = &gravitee_models.EndpointGroup{}
 	updateAPIEntity.Proxy.Groups[0].Name = "default-group"
@@ -167,6 +291,25 @@ func (c *APIController) UpdateAPI(apiEndpoint *platformv1beta1.APIEndpoint, targ
 	updateAPIEntity.Proxy.Groups[0].Endpoints[0].Target = *target
 	updateAPIEntity.Proxy.Groups[0].Endpoints[0].Type = "http"
 	updateAPIEntity.Proxy.Groups[0].Endpoints[0].Tenants = make([]string, 0)
+	if tls := apiEndpoint.Spec.TLS; tls != nil {
+		// the options are always sent, so that false is not replaced by the default of Gravitee
+		ssl := &gravitee_models.HTTPClientSslOptions{
+			TrustAll:         tls.TrustAll != nil && *tls.TrustAll,
+			HostnameVerifier: tls.HostnameVerifier == nil || *tls.HostnameVerifier,
+		}
+		if resolvedValues.CACertificate != "" {
+			ssl.SetTrustStore(&gravitee_models.PEMTrustStore{Content: resolvedValues.CACertificate})
+		}
+		if resolvedValues.ClientCertificate != "" {
+			ssl.SetKeyStore(&gravitee_models.PEMKeyStore{
+				CertContent: resolvedValues.ClientCertificate,
+				KeyContent:  resolvedValues.ClientKey,
+			})
+		}
+		// the endpoint inherits the SSL options of its group
+		updateAPIEntity.Proxy.Groups[0].Ssl = ssl
+		updateAPIEntity.Proxy.Groups[0].Endpoints[0].Inherit = true
+	}
 	updateAPIEntity.Proxy.Cors = &gravitee_models.Cors{}
 	updateAPIEntity.Proxy.Cors.Enabled = apiEndpoint.Spec.Cors.Enabled
 	updateAPIEntity.Proxy.Cors.AllowCredentials = apiEndpoint.Spec.Cors.AllowCredentials
@@ -176,11 +319,30 @@ func (c *APIController) UpdateAPI(apiEndpoint *platformv1beta1.APIEndpoint, targ
 	updateAPIEntity.Proxy.Cors.MaxAge = apiEndpoint.Spec.Cors.MaxAge
 	//updateAPIEntity.Proxy.Cors.ErrorStatusCode = apiEndpoint.Spec.Cors.ErrorStatusCode
 	updateAPIEntity.Proxy.Cors.RunPolicies = apiEndpoint.Spec.Cors.RunPolicies
+	if apiEndpoint.Spec.Logging != nil {
+		updateAPIEntity.Proxy.Logging = &gravitee_models.ProxyLogging{
+			Mode:      apiEndpoint.Spec.Logging.Mode,
+			Content:   apiEndpoint.Spec.Logging.Content,
+			Scope:     apiEndpoint.Spec.Logging.Scope,
+			Condition: apiEndpoint.Spec.Logging.Condition,
+		}
+	}
+	updateAPIEntity.ResponseTemplates = make(map[string]map[string]gravitee_models.ResponseTemplate)
+	for key, templates := range apiEndpoint.Spec.ResponseTemplates {
+		updateAPIEntity.ResponseTemplates[key] = make(map[string]gravitee_models.ResponseTemplate)
+		for mediaType, template := range templates {
+			updateAPIEntity.ResponseTemplates[key][mediaType] = gravitee_models.ResponseTemplate{
+				Status:  template.Status,
+				Headers: template.Headers,
+				Body:    template.Body,
+			}
+		}
+	}
 	updateAPIParams.SetBodyAPI(&updateAPIEntity)
 	updateAPIParams.SetTimeout(time.Second * time.Duration(c.Timeout))
 	updateAPIParams.SetOrgID(c.OrgID)
 	updateAPIParams.SetEnvID(c.EnvID)
-	_, err := c.client_apis.UpdateAPI(
+	_, err = c.client_apis.UpdateAPI(
 		&updateAPIParams,
 		c.authInfo,
 	)
@@ -188,8 +350,215 @@ func (c *APIController) UpdateAPI(apiEndpoint *platformv1beta1.APIEndpoint, targ
 		json_params, _ := json.Marshal(updateAPIParams)
 		l.Printf("updateAPIParams: %s", json_params)
 		l.Printf("error UpdateAPI: %s", err)
+		return err
 	}
-	return err
+	return nil
+}
+
+// apiLabels returns the labels of the API, including the mirrored Kubernetes labels and annotations
+func apiLabels(apiEndpoint *platformv1beta1.APIEndpoint) []string {
+	labels := make([]string, 0)
+	labels = append(labels, apiEndpoint.Spec.Labels...)
+	if apiEndpoint.Spec.MirrorLabels {
+		keys := make([]string, 0)
+		for k := range apiEndpoint.ObjectMeta.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			labels = append(labels, k+":"+apiEndpoint.ObjectMeta.Labels[k])
+		}
+	}
+	for _, k := range apiEndpoint.Spec.MirrorAnnotations {
+		if v, ok := apiEndpoint.ObjectMeta.Annotations[k]; ok {
+			labels = append(labels, k+":"+v)
+		}
+	}
+	return labels
+}
+
+// GetCategoryIDs resolves categories, given by name or key, to their IDs
+func (c *APIController) GetCategoryIDs(names []string) ([]string, error) {
+	ids := make([]string, 0)
+	if len(names) == 0 {
+		return ids, nil
+	}
+	getCategoriesParams := gravitee_categories.GetCategoriesParams{}
+	getCategoriesParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getCategoriesParams.SetOrgID(c.OrgID)
+	getCategoriesParams.SetEnvID(c.EnvID)
+	categories, err := c.client_categories.GetCategories(&getCategoriesParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to get categories %s", err)
+		return nil, classify(err, "unable to get the categories")
+	}
+	for _, name := range names {
+		var id string
+		for _, category := range categories.Payload {
+			if (category.Name != nil && *category.Name == name) || category.Key == name {
+				id = category.ID
+			}
+		}
+		if id == "" {
+			return nil, newManagementError(ErrorNotFound, "category %s not found", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetGroupIDs resolves groups, given by name, to their IDs
+func (c *APIController) GetGroupIDs(names []string) ([]string, error) {
+	ids := make([]string, 0)
+	if len(names) == 0 {
+		return ids, nil
+	}
+	getGroupsParams := gravitee_groups.GetGroupsParams{}
+	getGroupsParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getGroupsParams.SetOrgID(c.OrgID)
+	getGroupsParams.SetEnvID(c.EnvID)
+	groups, err := c.client_groups.GetGroups(&getGroupsParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to get groups %s", err)
+		return nil, classify(err, "unable to get the groups")
+	}
+	for _, name := range names {
+		var id string
+		for _, group := range groups.Payload {
+			if group.Name == name {
+				id = group.ID
+			}
+		}
+		if id == "" {
+			return nil, newManagementError(ErrorNotFound, "group %s not found", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// UpdateAPIMetadata creates and updates the metadata of the API declared in the CRD, matched by
+// name, and returns all the metadata of the API
+func (c *APIController) UpdateAPIMetadata(apiEndpoint *platformv1beta1.APIEndpoint) ([]*gravitee_models.APIMetadataEntity, error) {
+	getAPIMetadatasParams := gravitee_metadata.GetAPIMetadatasParams{
+		API: apiEndpoint.Status.ID,
+	}
+	getAPIMetadatasParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getAPIMetadatasParams.SetOrgID(c.OrgID)
+	getAPIMetadatasParams.SetEnvID(c.EnvID)
+	metadatas, err := c.client_metadata.GetAPIMetadatas(&getAPIMetadatasParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to get API metadata %s", err)
+		return nil, err
+	}
+	metadata_updated := make([]*gravitee_models.APIMetadataEntity, 0)
+	metadata_declared := make(map[string]bool)
+	// the management API does not return the hidden flag, the status keeps the hidden metadata
+	metadata_hidden := make([]string, 0)
+	for _, metadata_new := range apiEndpoint.Spec.Metadata {
+		name := metadata_new.Name
+		metadata_declared[name] = true
+		if metadata_new.Hidden {
+			metadata_hidden = append(metadata_hidden, name)
+		}
+		format := metadata_new.Format
+		if format == "" {
+			format = "STRING"
+		}
+		var metadata_ext *gravitee_models.APIMetadataEntity
+		for _, metadata := range metadatas.Payload {
+			if metadata.Name == name {
+				metadata_ext = metadata
+			}
+		}
+		if metadata_ext == nil {
+			createAPIMetadataParams := gravitee_metadata.CreateAPIMetadataParams{
+				API: apiEndpoint.Status.ID,
+				Body: &gravitee_models.NewAPIMetadataEntity{
+					Name:   &name,
+					Format: format,
+					Value:  metadata_new.Value,
+					Hidden: metadata_new.Hidden,
+				},
+			}
+			createAPIMetadataParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+			createAPIMetadataParams.SetOrgID(c.OrgID)
+			createAPIMetadataParams.SetEnvID(c.EnvID)
+			created, err := c.client_metadata.CreateAPIMetadata(&createAPIMetadataParams, c.authInfo)
+			if err != nil {
+				l.Printf("unable to create API metadata %s: %s", name, err)
+				return nil, err
+			}
+			metadata_updated = append(metadata_updated, created.Payload)
+			continue
+		}
+		if metadata_ext.Format == format && metadata_ext.Value != nil && *metadata_ext.Value == metadata_new.Value &&
+			metadata_new.Hidden == containsString(apiEndpoint.Status.HiddenMetadata, name) {
+			metadata_updated = append(metadata_updated, metadata_ext)
+			continue
+		}
+		if metadata_ext.Key == nil {
+			return nil, newManagementError(ErrorValidation, "metadata %s has no key", name)
+		}
+		updateAPIMetadataParams := gravitee_metadata.UpdateAPIMetadataParams{
+			API:      apiEndpoint.Status.ID,
+			Metadata: *metadata_ext.Key,
+			Body: &gravitee_models.UpdateAPIMetadataEntity{
+				Key:    *metadata_ext.Key,
+				Name:   &name,
+				Format: format,
+				Value:  metadata_new.Value,
+				Hidden: metadata_new.Hidden,
+			},
+		}
+		updateAPIMetadataParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+		updateAPIMetadataParams.SetOrgID(c.OrgID)
+		updateAPIMetadataParams.SetEnvID(c.EnvID)
+		updated, err := c.client_metadata.UpdateAPIMetadata(&updateAPIMetadataParams, c.authInfo)
+		if err != nil {
+			l.Printf("unable to update API metadata %s: %s", name, err)
+			return nil, err
+		}
+		metadata_updated = append(metadata_updated, updated.Payload)
+	}
+	for _, metadata := range metadatas.Payload {
+		if !metadata_declared[metadata.Name] {
+			metadata_updated = append(metadata_updated, metadata)
+		}
+	}
+	apiEndpoint.Status.HiddenMetadata = metadata_hidden
+	return metadata_updated, nil
+}
+
+// ImportAPIPathMappings adds the paths of the OpenAPI documentation page named by path_mappings_from_page
+// to the path mappings of the API. It runs after the update of the API, which sets the path mappings
+// of the spec, and of the pages, which gives the ID of the page.
+func (c *APIController) ImportAPIPathMappings(apiEndpoint *platformv1beta1.APIEndpoint) error {
+	if apiEndpoint.Spec.PathMappingsFromPage == "" {
+		return nil
+	}
+	pageID, err := apiEndpointPageID(apiEndpoint, apiEndpoint.Spec.PathMappingsFromPage)
+	if err != nil {
+		return err
+	}
+	return c.ImportAPIPathMappingsFromPage(apiEndpoint.Status.ID, pageID)
+}
+
+// ImportAPIPathMappingsFromPage adds the paths of an OpenAPI documentation page to the path mappings of the API
+func (c *APIController) ImportAPIPathMappingsFromPage(APIID string, PageID string) error {
+	importAPIPathMappingsFromPageParams := gravitee_apis.ImportAPIPathMappingsFromPageParams{
+		API:  APIID,
+		Page: PageID,
+	}
+	importAPIPathMappingsFromPageParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	importAPIPathMappingsFromPageParams.SetOrgID(c.OrgID)
+	importAPIPathMappingsFromPageParams.SetEnvID(c.EnvID)
+	_, err := c.client_apis.ImportAPIPathMappingsFromPage(&importAPIPathMappingsFromPageParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to import path mappings from page %s: %s", PageID, err)
+		return classify(err, "unable to import the path mappings of page %s", PageID)
+	}
+	return nil
 }
 
 func (c *APIController) DeployAPI(apiID string) error {
@@ -203,6 +572,10 @@ func (c *APIController) DeployAPI(apiID string) error {
 		&deployAPIParams,
 		c.authInfo,
 	)
+	if err != nil {
+		l.Printf("unable to DeployAPI err: %s", err)
+		return classify(err, "unable to deploy API %s", apiID)
+	}
 	doAPILifecycleActionParams := gravitee_apis.DoAPILifecycleActionParams{
 		API:    apiID,
 		Action: "START",
@@ -216,30 +589,50 @@ func (c *APIController) DeployAPI(apiID string) error {
 	)
 	if err != nil {
 		l.Printf("unable to DoLifecycleAction err: %s", err)
-		err = nil // API was already started, non a real error
+		// the management API refuses to start an API already started, non a real error
+		if class := ErrorClassOf(err); class != ErrorValidation && class != ErrorConflict {
+			return classify(err, "unable to start API %s", apiID)
+		}
 	}
-
-	return err
+	return nil
 }
 
-func (c *APIController) UpdateAPIPlans(apiEndpoint *platformv1beta1.APIEndpoint) error {
+func (c *APIController) GetAPIPlans(APIID string) ([]*gravitee_models.PlanEntity, error) {
 	getAPIPlansParams := gravitee_plans.GetAPIPlansParams{}
 	getAPIPlansParams.WithDefaults()
-	getAPIPlansParams.API = apiEndpoint.Status.ID
+	getAPIPlansParams.API = APIID
+	status := "staging,published,deprecated"
+	getAPIPlansParams.Status = &status
 	getAPIPlansParams.SetTimeout(time.Second * time.Duration(c.Timeout))
 	getAPIPlansParams.SetOrgID(c.OrgID)
 	getAPIPlansParams.SetEnvID(c.EnvID)
 	plans, err := c.client_plans.GetAPIPlans(&getAPIPlansParams, c.authInfo)
 	if err != nil {
 		l.Printf("ListPlans err: %s", err)
+		return nil, err
+	}
+	return plans.Payload, nil
+}
+
+func (c *APIController) UpdateAPIPlans(apiEndpoint *platformv1beta1.APIEndpoint, resolvedValues *ResolvedValues) error {
+	plans, err := c.GetAPIPlans(apiEndpoint.Status.ID)
+	if err != nil {
+		return err
 	}
 
 	// update existing or crete new
 	var plan_found bool
 	for _, plan_new := range apiEndpoint.Spec.Plans {
+		// the general conditions page is named in the spec, its ID is known once the pages are updated
+		var generalConditions string
+		if plan_new.GeneralConditions != "" {
+			if generalConditions, err = apiEndpointPageID(apiEndpoint, plan_new.GeneralConditions); err != nil {
+				return err
+			}
+		}
 		plan_found = false
-		for _, plan_ext := range plans.Payload {
-			if *plan_new.Name == plan_ext.Name {
+		for _, plan_ext := range plans {
+			if planMatches(plan_new, plan_ext, apiEndpoint.Spec.Plans) {
 				plan_found = true
 				updateAPIPlanParams := gravitee_plans.UpdateAPIPlanParams{}
 				updateAPIPlanParams.WithDefaults()
@@ -249,20 +642,47 @@ func (c *APIController) UpdateAPIPlans(apiEndpoint *platformv1beta1.APIEndpoint)
 				updateAPIPlanParams.BodyPlan = &gravitee_models.UpdatePlanEntity{}
 				updateAPIPlanParams.BodyPlan.Description = &plan_new.Description
 				updateAPIPlanParams.BodyPlan.Name = plan_new.Name
-				securityDefinition, _ := json.Marshal(plan_new.SecurityDefinition)
+				updateAPIPlanParams.BodyPlan.CrossID = plan_ext.CrossID
+				if plan_new.Key != "" {
+					updateAPIPlanParams.BodyPlan.CrossID = plan_new.Key
+				}
+				securityDefinition, _ := json.Marshal(resolvedValues.SecurityDefinitions[*plan_new.Name])
 				updateAPIPlanParams.BodyPlan.SecurityDefinition = string(securityDefinition)
-				updateAPIPlanParams.BodyPlan.Tags = plan_ext.Tags
-				updateAPIPlanParams.BodyPlan.Order = &plan_ext.Order
-				updateAPIPlanParams.BodyPlan.Validation = &plan_ext.Validation
+				updateAPIPlanParams.BodyPlan.Characteristics = append(make([]string, 0), plan_new.Characteristics...)
+				updateAPIPlanParams.BodyPlan.Tags = plan_new.Tags
+				if plan_new.Order != nil {
+					updateAPIPlanParams.BodyPlan.Order = plan_new.Order
+				} else {
+					updateAPIPlanParams.BodyPlan.Order = &plan_ext.Order
+				}
+				updateAPIPlanParams.BodyPlan.ExcludedGroups = append(make([]string, 0), plan_new.ExcludedGroups...)
+				updateAPIPlanParams.BodyPlan.CommentRequired = plan_new.CommentRequired
+				updateAPIPlanParams.BodyPlan.CommentMessage = plan_new.CommentMessage
+				updateAPIPlanParams.BodyPlan.SelectionRule = plan_new.SelectionRule
+				updateAPIPlanParams.BodyPlan.GeneralConditions = generalConditions
+				if plan_new.Validation != "" {
+					updateAPIPlanParams.BodyPlan.Validation = &plan_new.Validation
+				} else {
+					updateAPIPlanParams.BodyPlan.Validation = &plan_ext.Validation
+				}
 				updateAPIPlanParams.SetOrgID(c.OrgID)
 				updateAPIPlanParams.SetEnvID(c.EnvID)
 				_, err := c.client_plans.UpdateAPIPlan(&updateAPIPlanParams, c.authInfo)
 				if err != nil {
-					l.Panicf("Error updating plan: %s", err)
+					l.Printf("Error updating plan: %s", err)
+					return classify(err, "unable to update plan %s", *plan_new.Name)
+				}
+				if planStatus(plan_new) == "CLOSED" && plan_ext.Status != "CLOSED" {
+					if err := c.CheckAPIPlanRemoval(apiEndpoint, plan_ext); err != nil {
+						return err
+					}
+				}
+				if err := c.UpdateAPIPlanStatus(plan_ext, planStatus(plan_new)); err != nil {
+					return err
 				}
 			}
 		}
-		if plan_found == false {
+		if plan_found == false && planStatus(plan_new) != "CLOSED" {
 			createAPIPlanParams := gravitee_plans.CreateAPIPlanParams{}
 			createAPIPlanParams.WithDefaults()
 			createAPIPlanParams.SetAPI(apiEndpoint.Status.ID)
@@ -273,30 +693,54 @@ func (c *APIController) UpdateAPIPlans(apiEndpoint *platformv1beta1.APIEndpoint)
 			createAPIPlanParams.Plan.API = apiEndpoint.Status.ID
 			createAPIPlanParams.Plan.Description = &plan_new.Description
 			createAPIPlanParams.Plan.Name = plan_new.Name
+			createAPIPlanParams.Plan.CrossID = plan_new.Key
 			createAPIPlanParams.Plan.Security = plan_new.Security
-			securityDefinition, _ := json.Marshal(plan_new.SecurityDefinition)
+			createAPIPlanParams.Plan.Characteristics = append(make([]string, 0), plan_new.Characteristics...)
+			createAPIPlanParams.Plan.Tags = plan_new.Tags
+			if plan_new.Order != nil {
+				createAPIPlanParams.Plan.Order = *plan_new.Order
+			}
+			createAPIPlanParams.Plan.ExcludedGroups = append(make([]string, 0), plan_new.ExcludedGroups...)
+			createAPIPlanParams.Plan.CommentRequired = plan_new.CommentRequired
+			createAPIPlanParams.Plan.CommentMessage = plan_new.CommentMessage
+			createAPIPlanParams.Plan.SelectionRule = plan_new.SelectionRule
+			createAPIPlanParams.Plan.GeneralConditions = generalConditions
+			securityDefinition, _ := json.Marshal(resolvedValues.SecurityDefinitions[*plan_new.Name])
 			createAPIPlanParams.Plan.SecurityDefinition = string(securityDefinition)
 			status := "PUBLISHED"
+			if planStatus(plan_new) == "STAGING" {
+				status = "STAGING"
+			}
 			createAPIPlanParams.Plan.Status = &status
 			typ := "API"
 			createAPIPlanParams.Plan.Type = &typ
-			auto := "AUTO"
-			createAPIPlanParams.Plan.Validation = &auto
-			_, err := c.client_plans.CreateAPIPlan(&createAPIPlanParams, c.authInfo)
+			validation := "AUTO"
+			if plan_new.Validation != "" {
+				validation = plan_new.Validation
+			}
+			createAPIPlanParams.Plan.Validation = &validation
+			plan_created, err := c.client_plans.CreateAPIPlan(&createAPIPlanParams, c.authInfo)
 			if err != nil {
-				l.Panicf("Error creating plan: %s", err)
+				l.Printf("Error creating plan: %s", err)
+				return classify(err, "unable to create plan %s", *plan_new.Name)
+			}
+			if err := c.UpdateAPIPlanStatus(plan_created.Payload, planStatus(plan_new)); err != nil {
+				return err
 			}
 		}
 	}
 	// delete retired plans
-	for _, plan_ext := range plans.Payload {
+	for _, plan_ext := range plans {
 		plan_found = false
 		for _, plan_new := range apiEndpoint.Spec.Plans {
-			if *plan_new.Name == plan_ext.Name {
+			if planMatches(plan_new, plan_ext, apiEndpoint.Spec.Plans) {
 				plan_found = true
 			}
 		}
 		if plan_found == false {
+			if err := c.CheckAPIPlanRemoval(apiEndpoint, plan_ext); err != nil {
+				return err
+			}
 			closeAPIPlanParams := gravitee_plans.CloseAPIPlanParams{}
 			closeAPIPlanParams.WithDefaults()
 			closeAPIPlanParams.SetTimeout(time.Second * time.Duration(c.Timeout))
@@ -306,7 +750,8 @@ func (c *APIController) UpdateAPIPlans(apiEndpoint *platformv1beta1.APIEndpoint)
 			closeAPIPlanParams.SetPlan(plan_ext.ID)
 			_, _, err := c.client_plans.CloseAPIPlan(&closeAPIPlanParams, c.authInfo)
 			if err != nil {
-				l.Panicf("Error closing plan: %s", err)
+				l.Printf("Error closing plan: %s", err)
+				return classify(err, "unable to close plan %s", plan_ext.Name)
 			}
 			deleteAPIPlanParams := gravitee_plans.DeleteAPIPlanParams{}
 			deleteAPIPlanParams.WithDefaults()
@@ -317,10 +762,212 @@ func (c *APIController) UpdateAPIPlans(apiEndpoint *platformv1beta1.APIEndpoint)
 			deleteAPIPlanParams.SetPlan(plan_ext.ID)
 			_, err = c.client_plans.DeleteAPIPlan(&deleteAPIPlanParams, c.authInfo)
 			if err != nil {
-				l.Panicf("Error deleting plan: %s", err)
+				l.Printf("Error deleting plan: %s", err)
+				return classify(err, "unable to delete plan %s", plan_ext.Name)
 			}
 		}
 	}
+	plans, err = c.GetAPIPlans(apiEndpoint.Status.ID)
+	if err != nil {
+		return err
+	}
+	// transfer subscriptions of migrated plans, unless they have all been transferred to the
+	// same plan already
+	migrated := make(map[string]string)
+	for _, plan_new := range apiEndpoint.Spec.Plans {
+		if plan_new.MigrateTo == "" {
+			continue
+		}
+		if previous := previousPlanStatus(apiEndpoint, plan_new); previous != nil && previous.MigratedTo == plan_new.MigrateTo {
+			migrated[*plan_new.Name] = plan_new.MigrateTo
+			continue
+		}
+		var plan, target *gravitee_models.PlanEntity
+		target_new := apiEndpointPlan(apiEndpoint, plan_new.MigrateTo)
+		for _, plan_ext := range plans {
+			if planMatches(plan_new, plan_ext, apiEndpoint.Spec.Plans) {
+				plan = plan_ext
+			}
+			if target_new != nil && planMatches(target_new, plan_ext, apiEndpoint.Spec.Plans) {
+				target = plan_ext
+			}
+		}
+		if target_new == nil {
+			return newManagementError(ErrorValidation, "unable to migrate plan %s to plan %s, which is not declared", *plan_new.Name, plan_new.MigrateTo)
+		}
+		if plan == nil || target == nil {
+			return newManagementError(ErrorNotFound, "unable to migrate plan %s to plan %s", *plan_new.Name, plan_new.MigrateTo)
+		}
+		if err := c.MigrateAPIPlanSubscriptions(apiEndpoint.Status.ID, plan, target); err != nil {
+			return err
+		}
+		migrated[*plan_new.Name] = plan_new.MigrateTo
+	}
+	// report the plans status
+	apiEndpoint.Status.Plans = make([]platformv1beta1.PlanStatus, 0)
+	for _, plan_new := range apiEndpoint.Spec.Plans {
+		plan_status := platformv1beta1.PlanStatus{
+			Name:       *plan_new.Name,
+			Key:        plan_new.Key,
+			Status:     "CLOSED",
+			MigratedTo: migrated[*plan_new.Name],
+		}
+		for _, plan_ext := range plans {
+			if planMatches(plan_new, plan_ext, apiEndpoint.Spec.Plans) {
+				plan_status.ID = plan_ext.ID
+				plan_status.Status = plan_ext.Status
+			}
+		}
+		apiEndpoint.Status.Plans = append(apiEndpoint.Status.Plans, plan_status)
+	}
+	return nil
+}
+
+// UpdateAPIPlanStatus moves a plan along its lifecycle, STAGING -> PUBLISHED -> DEPRECATED -> CLOSED
+func (c *APIController) UpdateAPIPlanStatus(plan *gravitee_models.PlanEntity, status string) error {
+	if plan.Status == status {
+		return nil
+	}
+	switch {
+	case status == "CLOSED":
+		return c.CloseAPIPlan(plan.API, plan.ID)
+	case plan.Status == "STAGING" && status == "PUBLISHED":
+		return c.PublishAPIPlan(plan.API, plan.ID)
+	case plan.Status == "STAGING" && status == "DEPRECATED":
+		if err := c.PublishAPIPlan(plan.API, plan.ID); err != nil {
+			return err
+		}
+		return c.DepreciateAPIPlan(plan.API, plan.ID)
+	case plan.Status == "PUBLISHED" && status == "DEPRECATED":
+		return c.DepreciateAPIPlan(plan.API, plan.ID)
+	}
+	l.Printf("unable to change plan %s status from %s to %s", plan.Name, plan.Status, status)
+	return newManagementError(ErrorValidation, "unable to change plan %s status from %s to %s", plan.Name, plan.Status, status)
+}
+
+// CheckAPIPlanRemoval refuses to close or delete a plan with active subscriptions, unless the
+// APIEndpoint has the force plan removal annotation
+func (c *APIController) CheckAPIPlanRemoval(apiEndpoint *platformv1beta1.APIEndpoint, plan *gravitee_models.PlanEntity) error {
+	if apiEndpoint.ObjectMeta.Annotations[ForcePlanRemovalAnnotation] == "true" {
+		return nil
+	}
+	active, err := c.GetActiveAPISubscriptions(plan.API, plan.ID)
+	if err != nil {
+		return err
+	}
+	if len(active) > 0 {
+		return newManagementError(ErrorConflict, "plan %s still has %d active subscriptions, set the %s annotation to remove it", plan.Name, len(active), ForcePlanRemovalAnnotation)
+	}
+	return nil
+}
+
+func (c *APIController) PublishAPIPlan(APIID string, PlanID string) error {
+	publishAPIPlanParams := gravitee_plans.PublishAPIPlanParams{
+		API:  APIID,
+		Plan: PlanID,
+	}
+	publishAPIPlanParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	publishAPIPlanParams.SetOrgID(c.OrgID)
+	publishAPIPlanParams.SetEnvID(c.EnvID)
+	_, err := c.client_plans.PublishAPIPlan(&publishAPIPlanParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to PublishAPIPlan %s", err)
+	}
+	return err
+}
+
+func (c *APIController) CloseAPIPlan(APIID string, PlanID string) error {
+	closeAPIPlanParams := gravitee_plans.CloseAPIPlanParams{
+		API:  APIID,
+		Plan: PlanID,
+	}
+	closeAPIPlanParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	closeAPIPlanParams.SetOrgID(c.OrgID)
+	closeAPIPlanParams.SetEnvID(c.EnvID)
+	_, _, err := c.client_plans.CloseAPIPlan(&closeAPIPlanParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to CloseAPIPlan %s", err)
+	}
+	return err
+}
+
+func (c *APIController) DepreciateAPIPlan(APIID string, PlanID string) error {
+	depreciateAPIPlanParams := gravitee_plans.DepreciateAPIPlanParams{
+		API:  APIID,
+		Plan: PlanID,
+	}
+	depreciateAPIPlanParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	depreciateAPIPlanParams.SetOrgID(c.OrgID)
+	depreciateAPIPlanParams.SetEnvID(c.EnvID)
+	_, err := c.client_plans.DepreciateAPIPlan(&depreciateAPIPlanParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to DepreciateAPIPlan %s", err)
+	}
+	return err
+}
+
+// GetActiveAPISubscriptions returns the accepted, pending and paused subscriptions of a plan,
+// reading all the pages
+func (c *APIController) GetActiveAPISubscriptions(APIID string, PlanID string) ([]map[string]interface{}, error) {
+	active := make([]map[string]interface{}, 0)
+	for page := int32(1); ; page++ {
+		getAPISubscriptionsParams := gravitee_api_subs.GetAPISubscriptionsParams{
+			API:  APIID,
+			Plan: &PlanID,
+		}
+		status := "ACCEPTED,PENDING,PAUSED"
+		getAPISubscriptionsParams.Status = &status
+		size := int32(100)
+		getAPISubscriptionsParams.Size = &size
+		getAPISubscriptionsParams.Page = &page
+		getAPISubscriptionsParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+		getAPISubscriptionsParams.SetOrgID(c.OrgID)
+		getAPISubscriptionsParams.SetEnvID(c.EnvID)
+		subs, err := c.client_api_subs.GetAPISubscriptions(&getAPISubscriptionsParams, c.authInfo)
+		if err != nil {
+			l.Printf("unable to GetAPISubscriptions %s", err)
+			return nil, classify(err, "unable to get the subscriptions of plan %s", PlanID)
+		}
+		for _, sub := range subs.Payload.Data {
+			sub_map, ok := sub.(map[string]interface{})
+			if !ok || subscriptionString(sub_map, "id") == "" {
+				return nil, newManagementError(ErrorTransient, "unexpected subscription %v of plan %s", sub, PlanID)
+			}
+			active = append(active, sub_map)
+		}
+		if len(subs.Payload.Data) == 0 || subs.Payload.Page == nil || page >= subs.Payload.Page.TotalPages {
+			return active, nil
+		}
+	}
+}
+
+func (c *APIController) MigrateAPIPlanSubscriptions(APIID string, plan *gravitee_models.PlanEntity, target *gravitee_models.PlanEntity) error {
+	subs, err := c.GetActiveAPISubscriptions(APIID, plan.ID)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		subID := subscriptionString(sub, "id")
+		transferAPISubscriptionParams := gravitee_api_subs.TransferAPISubscriptionParams{
+			API:              APIID,
+			PathSubscription: subID,
+		}
+		transferAPISubscriptionParams.BodySubscription = &gravitee_models.TransferSubscriptionEntity{
+			ID:   subID,
+			Plan: &target.ID,
+		}
+		transferAPISubscriptionParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+		transferAPISubscriptionParams.SetOrgID(c.OrgID)
+		transferAPISubscriptionParams.SetEnvID(c.EnvID)
+		_, err = c.client_api_subs.TransferAPISubscription(&transferAPISubscriptionParams, c.authInfo)
+		if err != nil {
+			json_params, _ := json.Marshal(transferAPISubscriptionParams)
+			l.Printf("transferAPISubscriptionParams: %s", json_params)
+			l.Printf("unable to TransferAPISubscription %s", err)
+			return classify(err, "unable to transfer subscription %s to plan %s", subID, target.Name)
+		}
+		l.Printf("transferred subscription %s from plan %s to plan %s", subID, plan.Name, target.Name)
+	}
 	return nil
 }
 
@@ -340,18 +987,15 @@ func (c *APIController) DeleteAPI(apiEndpoint *platformv1beta1.APIEndpoint) erro
 		l.Printf("unable to DoLifecycleAction err: %s", err)
 		err = nil // API was already started, non a real error
 	}
-	getAPIPlansParams := gravitee_plans.GetAPIPlansParams{}
-	getAPIPlansParams.WithDefaults()
-	getAPIPlansParams.API = apiEndpoint.Status.ID
-	getAPIPlansParams.SetTimeout(time.Second * time.Duration(c.Timeout))
-	getAPIPlansParams.SetOrgID(c.OrgID)
-	getAPIPlansParams.SetEnvID(c.EnvID)
-	plans, err := c.client_plans.GetAPIPlans(&getAPIPlansParams, c.authInfo)
+	plans, err := c.GetAPIPlans(apiEndpoint.Status.ID)
+	if IsNotFound(err) {
+		// already deleted
+		return nil
+	}
 	if err != nil {
-		l.Printf("ListPlans err: %s", err)
-		return err
+		return classify(err, "unable to get the plans of API %s", apiEndpoint.Status.ID)
 	}
-	for _, plan := range plans.Payload {
+	for _, plan := range plans {
 		closeAPIPlanParams := gravitee_plans.CloseAPIPlanParams{}
 		closeAPIPlanParams.WithDefaults()
 		closeAPIPlanParams.SetTimeout(time.Second * time.Duration(c.Timeout))
@@ -360,9 +1004,9 @@ func (c *APIController) DeleteAPI(apiEndpoint *platformv1beta1.APIEndpoint) erro
 		closeAPIPlanParams.SetAPI(apiEndpoint.Status.ID)
 		closeAPIPlanParams.SetPlan(plan.ID)
 		_, _, err := c.client_plans.CloseAPIPlan(&closeAPIPlanParams, c.authInfo)
-		if err != nil {
-			l.Panicf("Error closing plan: %s", err)
-			continue
+		if err != nil && !IsNotFound(err) {
+			l.Printf("Error closing plan: %s", err)
+			return classify(err, "unable to close plan %s", plan.Name)
 		}
 		deleteAPIPlanParams := gravitee_plans.DeleteAPIPlanParams{}
 		deleteAPIPlanParams.WithDefaults()
@@ -372,9 +1016,9 @@ func (c *APIController) DeleteAPI(apiEndpoint *platformv1beta1.APIEndpoint) erro
 		deleteAPIPlanParams.SetAPI(apiEndpoint.Status.ID)
 		deleteAPIPlanParams.SetPlan(plan.ID)
 		_, err = c.client_plans.DeleteAPIPlan(&deleteAPIPlanParams, c.authInfo)
-		if err != nil {
-			l.Panicf("Error deleting plan: %s", err)
-			continue
+		if err != nil && !IsNotFound(err) {
+			l.Printf("Error deleting plan: %s", err)
+			return classify(err, "unable to delete plan %s", plan.Name)
 		}
 	}
 	deleteAPIParams := gravitee_apis.DeleteAPIParams{}
@@ -383,16 +1027,19 @@ func (c *APIController) DeleteAPI(apiEndpoint *platformv1beta1.APIEndpoint) erro
 	deleteAPIParams.SetOrgID(c.OrgID)
 	deleteAPIParams.SetEnvID(c.EnvID)
 	_, err = c.client_apis.DeleteAPI(&deleteAPIParams, c.authInfo)
-	if err != nil {
+	if err != nil && !IsNotFound(err) {
 		l.Printf("error DeleteAPI API: %v", err)
-		return err
+		return classify(err, "unable to delete API %s", apiEndpoint.Status.ID)
 	}
 	return nil
 }
 
-func (c *APIController) UpdateAPISubscriptions(apiClient *platformv1beta1.APIClient, ctx context.Context) error {
+// UpdateAPISubscriptions creates, updates and closes the subscriptions of the application. The
+// plan of a subscription is resolved through the APIEndpoint exposing the API when the operator
+// manages it, see apiEndpointPlan, so that the plans can be renamed.
+func (c *APIController) UpdateAPISubscriptions(apiClient *platformv1beta1.APIClient, apiEndpoints []platformv1beta1.APIEndpoint, ctx context.Context) error {
 	log := log.FromContext(ctx)
-	// list existing subscriptions and create a map <context_path-plan>:<subsciption>
+	// list existing subscriptions and create a map <api>/<plan>:<subsciption>
 	getApplicationSubscriptionsParams := gravitee_subs.GetApplicationSubscriptionsParams{}
 	getApplicationSubscriptionsParams.Application = apiClient.Status.ID
 	getApplicationSubscriptionsParams.SetTimeout(time.Second * time.Duration(c.Timeout))
@@ -400,45 +1047,56 @@ func (c *APIController) UpdateAPISubscriptions(apiClient *platformv1beta1.APICli
 	getApplicationSubscriptionsParams.SetEnvID(c.EnvID)
 	subs, err := c.client_subs.GetApplicationSubscriptions(&getApplicationSubscriptionsParams, c.authInfo)
 	if err != nil {
-		l.Panicf("unable to GetApplicationSubscriptions %s", err)
-		return err
+		l.Printf("unable to GetApplicationSubscriptions %s", err)
+		return classify(err, "unable to get the subscriptions of application %s", apiClient.Status.ID)
 	}
-
-	subs_ext := make(map[string]interface{})
+	subs_ext_data := make(map[string]map[string]interface{})
 	for _, sub_ext := range subs.Payload.Data {
-		sub_ext_map := sub_ext.(map[string]interface{})
-		getAPIParams := &gravitee_apis.GetAPIParams{
-			API: sub_ext_map["api"].(string),
-		}
-		getAPIParams.SetTimeout(time.Second * time.Duration(c.Timeout))
-		getAPIParams.SetOrgID(c.OrgID)
-		getAPIParams.SetEnvID(c.EnvID)
-		api, err := c.client_apis.GetAPI(getAPIParams, c.authInfo)
-		if err != nil {
-			l.Panicf("unable to get API %s", err)
-			return err
-		}
-		plan, err := c.GetPlan(sub_ext_map["api"].(string), sub_ext_map["plan"].(string))
-		if err != nil {
-			l.Panicf("unable to get Plan %s", err)
-			return err
+		sub_ext_map, ok := sub_ext.(map[string]interface{})
+		if !ok {
+			return newManagementError(ErrorValidation, "unexpected subscription of application %s: %v", apiClient.Status.ID, sub_ext)
 		}
-		subs_ext[api.Payload.ContextPath+"-"+plan.Name] = plan
+		subs_ext_data[subscriptionString(sub_ext_map, "api")+"/"+subscriptionString(sub_ext_map, "plan")] = sub_ext_map
 	}
 
-	// check for new subscriptions and create them
+	// check for new subscriptions and create them, apply dates and pause to the existing ones
+	subs_status := make([]platformv1beta1.APISubscriptionStatus, 0)
+	subs_kept := make(map[string]bool)
+	now := time.Now()
 	for _, sub_new := range apiClient.Spec.APISubscriptions {
-		if _, ok := subs_ext[sub_new.APIContextPath+"-"+sub_new.APIPlanName]; ok == false {
-			api_list_item, err := c.SearchAPI(sub_new.APIContextPath)
-			if err != nil {
-				l.Printf("unable to get API by ContextPath %s", err)
-				return err
-			}
-			plan, err := c.GetPlanByName(api_list_item.ID, sub_new.APIPlanName)
-			if err != nil {
-				l.Printf("unable to get Plan by name %s", err)
-				return err
+		sub_key := sub_new.APIContextPath + "-" + sub_new.APIPlanName
+		sub_status := platformv1beta1.APISubscriptionStatus{
+			APIContextPath: sub_new.APIContextPath,
+			APIPlanName:    sub_new.APIPlanName,
+		}
+		apiID, plan, err := c.SubscriptionPlan(apiClient, &sub_new, apiEndpoints)
+		expired := sub_new.EndingAt != nil && sub_new.EndingAt.Time.Before(now)
+		if err != nil && !(expired && IsNotFound(err)) {
+			l.Printf("unable to get the plan of subscription %s: %s", sub_key, err)
+			return err
+		}
+		var sub_ext_map map[string]interface{}
+		ok := false
+		if plan != nil {
+			sub_ext_map, ok = subs_ext_data[apiID+"/"+plan.ID]
+		}
+		if expired {
+			// expired subscription, close it if still open
+			if ok {
+				sub_status.ID = subscriptionString(sub_ext_map, "id")
+				subs_kept[sub_status.ID] = true
+				_, err = c.ChangeAPISubscriptionStatus(apiID, sub_status.ID, "CLOSED")
+				if err != nil {
+					return err
+				}
+				log.V(0).Info("closing expired subscription", "subscription", sub_key)
 			}
+			sub_status.Status = "EXPIRED"
+			subs_status = append(subs_status, sub_status)
+			continue
+		}
+		var sub *gravitee_models.Subscription
+		if ok == false {
 			createSubscriptionWithApplicationParams := gravitee_subs.CreateSubscriptionWithApplicationParams{
 				Application: apiClient.Status.ID,
 				Plan:        plan.ID,
@@ -446,28 +1104,58 @@ func (c *APIController) UpdateAPISubscriptions(apiClient *platformv1beta1.APICli
 			createSubscriptionWithApplicationParams.SetTimeout(time.Second * time.Duration(c.Timeout))
 			createSubscriptionWithApplicationParams.SetOrgID(c.OrgID)
 			createSubscriptionWithApplicationParams.SetEnvID(c.EnvID)
-			c.client_subs.CreateSubscriptionWithApplication(&createSubscriptionWithApplicationParams, c.authInfo)
-			log.V(0).Info("creating subscription", "subscription", sub_new.APIContextPath+"-"+sub_new.APIPlanName)
+			created, err := c.client_subs.CreateSubscriptionWithApplication(&createSubscriptionWithApplicationParams, c.authInfo)
+			if err != nil {
+				l.Printf("unable to create Subscription %s", err)
+				return classify(err, "unable to subscribe to plan %s", plan.ID)
+			}
+			log.V(0).Info("creating subscription", "subscription", sub_key)
+			sub = created.Payload
+			sub_ext_map = map[string]interface{}{
+				"id":     sub.ID,
+				"api":    apiID,
+				"status": sub.Status,
+			}
 		}
-	}
-	// check for obsolete subscriptions and close them
-	var sub_found bool
-	for sub_key, sub_ext := range subs_ext {
-		sub_found = false
-		for _, sub_new := range apiClient.Spec.APISubscriptions {
-			if sub_key == sub_new.APIContextPath+"-"+sub_new.APIPlanName {
-				sub_found = true
-			}
-		}
-		if sub_found == false {
-			var sub_id string
-			for _, sub := range subs.Payload.Data {
-				sub_map := sub.(map[string]interface{})
-				if sub_map["plan"].(string) == sub_ext.(*gravitee_models.PlanEntity).ID {
-					sub_id = sub_map["id"].(string)
-				}
+		sub_status.ID = subscriptionString(sub_ext_map, "id")
+		sub_status.Status = subscriptionString(sub_ext_map, "status")
+		subs_kept[sub_status.ID] = true
 
+		// apply starting and ending dates
+		if startingAt, endingAt, changed := subscriptionDates(&sub_new, sub_ext_map); changed {
+			sub, err = c.UpdateAPISubscription(apiID, sub_status.ID, startingAt, endingAt)
+			if err != nil {
+				return err
 			}
+			log.V(0).Info("updating subscription dates", "subscription", sub_key)
+			sub_status.Status = sub.Status
+		}
+
+		// pause or resume the subscription
+		if sub_new.Paused && sub_status.Status == "ACCEPTED" {
+			sub, err = c.ChangeAPISubscriptionStatus(apiID, sub_status.ID, "PAUSED")
+			if err != nil {
+				return err
+			}
+			log.V(0).Info("pausing subscription", "subscription", sub_key)
+			sub_status.Status = sub.Status
+		} else if !sub_new.Paused && sub_status.Status == "PAUSED" {
+			sub, err = c.ChangeAPISubscriptionStatus(apiID, sub_status.ID, "RESUMED")
+			if err != nil {
+				return err
+			}
+			log.V(0).Info("resuming subscription", "subscription", sub_key)
+			sub_status.Status = sub.Status
+		}
+		subs_status = append(subs_status, sub_status)
+	}
+	apiClient.Status.Subscriptions = subs_status
+
+	// check for obsolete subscriptions and close them
+	for sub_key, sub_ext_map := range subs_ext_data {
+		sub_id := subscriptionString(sub_ext_map, "id")
+		if subs_kept[sub_id] == false {
+			subs_kept[sub_id] = true
 			closeApplicationSubscriptionParams := gravitee_subs.CloseApplicationSubscriptionParams{
 				Application:  apiClient.Status.ID,
 				Subscription: sub_id,
@@ -484,7 +1172,94 @@ func (c *APIController) UpdateAPISubscriptions(apiClient *platformv1beta1.APICli
 			log.V(0).Info("closing subscription", "subscription", sub_key)
 		}
 	}
-	return err
+	return nil
+}
+
+// SubscriptionPlan returns the API and the plan of a subscription. When an APIEndpoint of the
+// same connection exposes the API, the plan is the one of the APIEndpoint named, or keyed, as
+// the subscription says; otherwise the API is searched by context path and the plan by name.
+func (c *APIController) SubscriptionPlan(apiClient *platformv1beta1.APIClient, sub_new *platformv1beta1.APISubscription, apiEndpoints []platformv1beta1.APIEndpoint) (string, *gravitee_models.PlanEntity, error) {
+	for i := range apiEndpoints {
+		apiEndpoint := &apiEndpoints[i]
+		if apiEndpoint.Status.ID == "" || !sameConnection(apiEndpoint.Spec.Connection, apiEndpoint.Namespace, apiClient.Spec.Connection, apiClient.Namespace) {
+			continue
+		}
+		exposed := false
+		for _, virtualHost := range apiVirtualHosts(apiEndpoint) {
+			exposed = exposed || virtualHost.Path == sub_new.APIContextPath
+		}
+		if !exposed {
+			continue
+		}
+		plan_new := apiEndpointPlan(apiEndpoint, sub_new.APIPlanName)
+		if plan_new == nil {
+			return "", nil, newManagementError(ErrorNotFound, "APIEndpoint %s has no plan %s", apiEndpoint.Name, sub_new.APIPlanName)
+		}
+		plan, err := c.FindAPIPlan(apiEndpoint.Status.ID, plan_new, apiEndpoint.Spec.Plans)
+		return apiEndpoint.Status.ID, plan, err
+	}
+	api_list_item, err := c.SearchAPI(sub_new.APIContextPath)
+	if err != nil {
+		l.Printf("unable to get API by ContextPath %s", err)
+		return "", nil, err
+	}
+	plan, err := c.FindAPIPlan(api_list_item.ID, &platformv1beta1.Plan{Name: &sub_new.APIPlanName}, nil)
+	return api_list_item.ID, plan, err
+}
+
+// sameConnection tells whether two connection references, of resources in the given
+// namespaces, select the same connection
+func sameConnection(a *platformv1beta1.ConnectionReference, aNamespace string, b *platformv1beta1.ConnectionReference, bNamespace string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.Namespace != "" {
+		aNamespace = a.Namespace
+	}
+	if b.Namespace != "" {
+		bNamespace = b.Namespace
+	}
+	return a.Name == b.Name && aNamespace == bNamespace
+}
+
+func (c *APIController) UpdateAPISubscription(APIID string, SubscriptionID string, StartingAt int64, EndingAt int64) (*gravitee_models.Subscription, error) {
+	updateAPISubscriptionParams := gravitee_api_subs.UpdateAPISubscriptionParams{
+		API:              APIID,
+		PathSubscription: SubscriptionID,
+	}
+	updateAPISubscriptionParams.BodySubscription = &gravitee_models.UpdateSubscriptionEntity{
+		ID:         SubscriptionID,
+		StartingAt: StartingAt,
+		EndingAt:   EndingAt,
+	}
+	updateAPISubscriptionParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	updateAPISubscriptionParams.SetOrgID(c.OrgID)
+	updateAPISubscriptionParams.SetEnvID(c.EnvID)
+	sub, err := c.client_api_subs.UpdateAPISubscription(&updateAPISubscriptionParams, c.authInfo)
+	if err != nil {
+		json_params, _ := json.Marshal(updateAPISubscriptionParams)
+		l.Printf("updateAPISubscriptionParams: %s", json_params)
+		l.Printf("unable to UpdateAPISubscription %s", err)
+		return nil, classify(err, "unable to update subscription %s", SubscriptionID)
+	}
+	return sub.Payload, nil
+}
+
+func (c *APIController) ChangeAPISubscriptionStatus(APIID string, SubscriptionID string, Status string) (*gravitee_models.Subscription, error) {
+	changeAPISubscriptionStatusParams := gravitee_api_subs.ChangeAPISubscriptionStatusParams{
+		API:          APIID,
+		Subscription: SubscriptionID,
+		Status:       Status,
+	}
+	changeAPISubscriptionStatusParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	changeAPISubscriptionStatusParams.SetOrgID(c.OrgID)
+	changeAPISubscriptionStatusParams.SetEnvID(c.EnvID)
+	sub, err := c.client_api_subs.ChangeAPISubscriptionStatus(&changeAPISubscriptionStatusParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to ChangeAPISubscriptionStatus to %s %s", Status, err)
+		return nil, classify(err, "unable to change the status of subscription %s to %s", SubscriptionID, Status)
+	}
+	return sub.Payload, nil
 }
 
 func (c *APIController) GetPlan(APIID string, PlanID string) (*gravitee_models.PlanEntity, error) {
@@ -496,28 +1271,88 @@ func (c *APIController) GetPlan(APIID string, PlanID string) (*gravitee_models.P
 	getAPIPlanParams.SetOrgID(c.OrgID)
 	getAPIPlanParams.SetEnvID(c.EnvID)
 	plan, err := c.client_plans.GetAPIPlan(&getAPIPlanParams, c.authInfo)
-	return plan.Payload, err
+	if err != nil {
+		l.Printf("unable to GetAPIPlan %s", err)
+		return nil, classify(err, "unable to get plan %s", PlanID)
+	}
+	return plan.Payload, nil
 }
 
-func (c *APIController) GetPlanByName(APIID string, PlanName string) (*gravitee_models.PlanEntity, error) {
-	getAPIPlanParams := gravitee_plans.GetAPIPlansParams{
-		API: APIID,
+// FindAPIPlan returns the plan of the API matching a plan of the CRD, see planMatches
+func (c *APIController) FindAPIPlan(APIID string, plan_new *platformv1beta1.Plan, plans_new []*platformv1beta1.Plan) (*gravitee_models.PlanEntity, error) {
+	plans, err := c.GetAPIPlans(APIID)
+	if err != nil {
+		return nil, classify(err, "unable to get the plans of API %s", APIID)
 	}
-	getAPIPlanParams.SetTimeout(time.Second * time.Duration(c.Timeout))
-	getAPIPlanParams.SetOrgID(c.OrgID)
-	getAPIPlanParams.SetEnvID(c.EnvID)
+	for _, plan := range plans {
+		if planMatches(plan_new, plan, plans_new) {
+			return plan, nil
+		}
+	}
+	return nil, newManagementError(ErrorNotFound, "plan %s not found in API %s", *plan_new.Name, APIID)
+}
 
-	plans, err := c.client_plans.GetAPIPlans(&getAPIPlanParams, c.authInfo)
+func (c *APIController) GetPendingAPISubscription(APIID string, AppID string, PlanID string) (string, error) {
+	subs, err := c.GetAPISubscriptions(APIID, AppID, PlanID, "PENDING")
 	if err != nil {
-		l.Printf("unable to search Plans %s", err)
-		return nil, err
+		return "", err
 	}
-	for _, plan := range plans.Payload {
-		if plan.Name == PlanName {
-			return plan, nil
+	for _, sub_map := range subs {
+		return subscriptionString(sub_map, "id"), nil
+	}
+	return "", nil
+}
+
+// GetAPISubscriptions returns the subscriptions of an application to a plan in the given status
+func (c *APIController) GetAPISubscriptions(APIID string, AppID string, PlanID string, Status string) ([]map[string]interface{}, error) {
+	getAPISubscriptionsParams := gravitee_api_subs.GetAPISubscriptionsParams{
+		API:         APIID,
+		Application: &AppID,
+		Plan:        &PlanID,
+		Status:      &Status,
+	}
+	getAPISubscriptionsParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getAPISubscriptionsParams.SetOrgID(c.OrgID)
+	getAPISubscriptionsParams.SetEnvID(c.EnvID)
+	subs, err := c.client_api_subs.GetAPISubscriptions(&getAPISubscriptionsParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to GetAPISubscriptions %s", err)
+		return nil, classify(err, "unable to get the %s subscriptions to plan %s", Status, PlanID)
+	}
+	subs_map := make([]map[string]interface{}, 0, len(subs.Payload.Data))
+	for _, sub := range subs.Payload.Data {
+		sub_map, ok := sub.(map[string]interface{})
+		if !ok || subscriptionString(sub_map, "id") == "" {
+			return nil, newManagementError(ErrorTransient, "unexpected subscription %v to plan %s", sub, PlanID)
 		}
+		subs_map = append(subs_map, sub_map)
+	}
+	return subs_map, nil
+}
+
+func (c *APIController) ProcessAPISubscription(APIID string, approval *platformv1beta1.APISubscriptionApproval) (*gravitee_models.Subscription, error) {
+	processAPISubscriptionParams := gravitee_api_subs.ProcessAPISubscriptionParams{
+		API:              APIID,
+		PathSubscription: approval.Status.SubscriptionID,
+	}
+	processAPISubscriptionParams.BodySubscription = &gravitee_models.ProcessSubscriptionEntity{}
+	processAPISubscriptionParams.BodySubscription.ID = approval.Status.SubscriptionID
+	processAPISubscriptionParams.BodySubscription.Accepted = &approval.Spec.Accepted
+	processAPISubscriptionParams.BodySubscription.Reason = approval.Spec.Reason
+	if approval.Spec.EndingAt != nil {
+		processAPISubscriptionParams.BodySubscription.EndingAt = approval.Spec.EndingAt.UnixMilli()
+	}
+	processAPISubscriptionParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	processAPISubscriptionParams.SetOrgID(c.OrgID)
+	processAPISubscriptionParams.SetEnvID(c.EnvID)
+	sub, err := c.client_api_subs.ProcessAPISubscription(&processAPISubscriptionParams, c.authInfo)
+	if err != nil {
+		json_params, _ := json.Marshal(processAPISubscriptionParams)
+		l.Printf("processAPISubscriptionParams: %s", json_params)
+		l.Printf("unable to ProcessAPISubscription %s", err)
+		return nil, err
 	}
-	return nil, errors.New("unable to find exact one plan")
+	return sub.Payload, err
 }
 
 func (c *APIController) GetApplication(AppID string) (*gravitee_models.ApplicationEntity, error) {
@@ -591,14 +1426,21 @@ func (c *APIController) DeleteApplication(apiClient *platformv1beta1.APIClient)
 	if err != nil {
 		json_params, _ := json.Marshal(getApplicationSubscriptionsParams)
 		l.Printf("getApplicationSubscriptionsParams: %s", json_params)
-		l.Panicf("unable to GetApplicationSubscriptions %s", err)
-		return err
+		l.Printf("unable to GetApplicationSubscriptions %s", err)
+		if IsNotFound(err) {
+			// already deleted
+			return nil
+		}
+		return classify(err, "unable to get the subscriptions of application %s", apiClient.Status.ID)
 	}
 	for _, sub_ext := range subs.Payload.Data {
-		sub_ext_map := sub_ext.(map[string]interface{})
+		sub_ext_map, ok := sub_ext.(map[string]interface{})
+		if !ok || subscriptionString(sub_ext_map, "id") == "" {
+			return newManagementError(ErrorTransient, "unexpected subscription %v of application %s", sub_ext, apiClient.Status.ID)
+		}
 		closeApplicationSubscriptionParams := gravitee_subs.CloseApplicationSubscriptionParams{
 			Application:  apiClient.Status.ID,
-			Subscription: sub_ext_map["id"].(string),
+			Subscription: subscriptionString(sub_ext_map, "id"),
 		}
 		closeApplicationSubscriptionParams.SetTimeout(time.Second * time.Duration(c.Timeout))
 		closeApplicationSubscriptionParams.SetOrgID(c.OrgID)
@@ -608,7 +1450,7 @@ func (c *APIController) DeleteApplication(apiClient *platformv1beta1.APIClient)
 			json_params, _ := json.Marshal(closeApplicationSubscriptionParams)
 			l.Printf("closeApplicationSubscriptionParams: %s", json_params)
 			l.Printf("unable to close Plan %s", err)
-			return err
+			return classify(err, "unable to close subscription %s", subscriptionString(sub_ext_map, "id"))
 		}
 	}
 	deleteApplicationParams := gravitee_apps.DeleteApplicationParams{
@@ -618,16 +1460,16 @@ func (c *APIController) DeleteApplication(apiClient *platformv1beta1.APIClient)
 	deleteApplicationParams.SetOrgID(c.OrgID)
 	deleteApplicationParams.SetEnvID(c.EnvID)
 	_, err = c.client_apps.DeleteApplication(&deleteApplicationParams, c.authInfo)
-	if err != nil {
+	if err != nil && !IsNotFound(err) {
 		json_params, _ := json.Marshal(deleteApplicationParams)
 		l.Printf("deleteApplicationParams: %s", json_params)
 		l.Printf("unable to delete Application %s", err)
-		return err
+		return classify(err, "unable to delete application %s", apiClient.Status.ID)
 	}
 	return nil
 }
 
-func (c *APIController) GetAPIAnalytics(apiEndpoint *platformv1beta1.APIEndpoint) (map[string]float64, error) {
+func (c *APIController) GetAPIAnalytics(apiEndpoint *platformv1beta1.APIEndpoint, ctx context.Context) (map[string]float64, error) {
 	p_type := "stats"
 	p_field := "response-time"
 	t_interval := time.Second * time.Duration(10)
@@ -647,22 +1489,337 @@ func (c *APIController) GetAPIAnalytics(apiEndpoint *platformv1beta1.APIEndpoint
 	getAPIAnalyticsHitsParams.SetFrom(&p_from)
 	getAPIAnalyticsHitsParams.SetTo(&p_to)
 	getAPIAnalyticsHitsParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getAPIAnalyticsHitsParams.SetContext(ctx)
 	results, err := c.client_analytics.GetAPIAnalyticsHits(&getAPIAnalyticsHitsParams, c.authInfo)
 	// json_params, _ := json.Marshal(getAPIAnalyticsHitsParams)
 	// l.Printf("getAPIAnalyticsHitsParams: %s", json_params)
 	if err != nil {
 		l.Printf("unable to GetAPIAnalyticsHits %s", err)
-		return nil, err
+		return nil, classify(err, "unable to get the analytics of API %s", apiEndpoint.Status.ID)
 	}
-	analytics := make(map[string]float64)
-	for k, v := range results.Payload.(map[string]interface{}) {
-		analytics[k], _ = v.(json.Number).Float64()
+	payload, ok := results.Payload.(map[string]interface{})
+	if !ok {
+		return nil, newManagementError(ErrorTransient, "unexpected analytics %v of API %s", results.Payload, apiEndpoint.Status.ID)
+	}
+	analytics, err := analyticsValues(payload)
+	if err != nil {
+		return nil, newManagementError(ErrorTransient, "unexpected analytics of API %s: %w", apiEndpoint.Status.ID, err)
 	}
 	// json_results, _ := json.Marshal(results.Payload)
 	// l.Printf("GetAPIAnalyticsHitsResults: %s", json_results)
 	return analytics, nil
 }
 
+// UpdateAPIPages creates and updates the documentation pages of the API, matched by name, and
+// deletes the pages previously created by the operator that are no longer declared
+func (c *APIController) UpdateAPIPages(apiEndpoint *platformv1beta1.APIEndpoint, resolvedValues *ResolvedValues) error {
+	pages_ext, err := c.GetAPIPages(apiEndpoint.Status.ID)
+	if err != nil {
+		return err
+	}
+	pages_ids := make(map[string]string)
+	for _, page_ext := range pages_ext {
+		if page_ext.Type != "ROOT" && page_ext.Type != "SYSTEM_FOLDER" {
+			pages_ids[page_ext.Name] = page_ext.ID
+		}
+	}
+
+	pages_status := make([]platformv1beta1.PageStatus, 0)
+	pages_declared := make(map[string]string)
+	for _, page_new := range apiEndpoint.Spec.Pages {
+		var parentID string
+		if page_new.Folder != "" {
+			var ok bool
+			if parentID, ok = pages_declared[page_new.Folder]; !ok {
+				return newManagementError(ErrorValidation, "folder %s of page %s must be declared before the page", page_new.Folder, page_new.Name)
+			}
+		}
+		name := page_new.Name
+		var page *gravitee_models.PageEntity
+		if pageID, ok := pages_ids[name]; ok {
+			page, err = c.UpdateAPIPage(apiEndpoint.Status.ID, pageID, &gravitee_models.UpdatePageEntity{
+				Name:      &name,
+				Content:   resolvedValues.Pages[name],
+				ParentID:  parentID,
+				Order:     page_new.Order,
+				Published: page_new.Published,
+				Homepage:  page_new.Homepage,
+			})
+		} else {
+			pageType := page_new.Type
+			page, err = c.CreateAPIPage(apiEndpoint.Status.ID, &gravitee_models.NewPageEntity{
+				Name:      &name,
+				Type:      &pageType,
+				Content:   resolvedValues.Pages[name],
+				ParentID:  parentID,
+				Order:     page_new.Order,
+				Published: page_new.Published,
+				Homepage:  page_new.Homepage,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		pages_declared[name] = page.ID
+		pages_status = append(pages_status, platformv1beta1.PageStatus{Name: name, ID: page.ID})
+	}
+
+	// delete the pages no longer declared, the pages before their folders
+	for i := len(apiEndpoint.Status.Pages) - 1; i >= 0; i-- {
+		page_status := apiEndpoint.Status.Pages[i]
+		if _, ok := pages_declared[page_status.Name]; ok {
+			continue
+		}
+		if err = c.DeleteAPIPage(apiEndpoint.Status.ID, page_status.ID); err != nil {
+			return err
+		}
+	}
+	apiEndpoint.Status.Pages = pages_status
+	return nil
+}
+
+func (c *APIController) GetAPIPages(APIID string) ([]*gravitee_models.PageEntity, error) {
+	getAPIPagesParams := gravitee_pages.GetAPIPagesParams{
+		API: APIID,
+	}
+	getAPIPagesParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getAPIPagesParams.SetOrgID(c.OrgID)
+	getAPIPagesParams.SetEnvID(c.EnvID)
+	pages, err := c.client_pages.GetAPIPages(&getAPIPagesParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to get API pages %s", err)
+		return nil, err
+	}
+	return pages.Payload, nil
+}
+
+func (c *APIController) CreateAPIPage(APIID string, page *gravitee_models.NewPageEntity) (*gravitee_models.PageEntity, error) {
+	createAPIPageParams := gravitee_pages.CreateAPIPageParams{
+		API:  APIID,
+		Page: page,
+	}
+	createAPIPageParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	createAPIPageParams.SetOrgID(c.OrgID)
+	createAPIPageParams.SetEnvID(c.EnvID)
+	created, err := c.client_pages.CreateAPIPage(&createAPIPageParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to create API page %s: %s", *page.Name, err)
+		return nil, err
+	}
+	return created.Payload, nil
+}
+
+func (c *APIController) UpdateAPIPage(APIID string, PageID string, page *gravitee_models.UpdatePageEntity) (*gravitee_models.PageEntity, error) {
+	updateAPIPageParams := gravitee_pages.UpdateAPIPageParams{
+		API:      APIID,
+		PathPage: PageID,
+		BodyPage: page,
+	}
+	updateAPIPageParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	updateAPIPageParams.SetOrgID(c.OrgID)
+	updateAPIPageParams.SetEnvID(c.EnvID)
+	updatedOK, updatedCreated, err := c.client_pages.UpdateAPIPage(&updateAPIPageParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to update API page %s: %s", *page.Name, err)
+		return nil, err
+	}
+	if updatedOK != nil {
+		return updatedOK.Payload, nil
+	}
+	return updatedCreated.Payload, nil
+}
+
+func (c *APIController) DeleteAPIPage(APIID string, PageID string) error {
+	deleteAPIPageParams := gravitee_pages.DeleteAPIPageParams{
+		API:  APIID,
+		Page: PageID,
+	}
+	deleteAPIPageParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	deleteAPIPageParams.SetOrgID(c.OrgID)
+	deleteAPIPageParams.SetEnvID(c.EnvID)
+	_, err := c.client_pages.DeleteAPIPage(&deleteAPIPageParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to delete API page %s: %s", PageID, err)
+	}
+	return err
+}
+
+// GetAPIPathMappingAnalytics returns the number of hits of each path mapping of the API
+func (c *APIController) GetAPIPathMappingAnalytics(apiEndpoint *platformv1beta1.APIEndpoint, ctx context.Context) (map[string]float64, error) {
+	p_type := "group_by"
+	p_field := "mapped-path"
+	t_interval := time.Second * time.Duration(10)
+	t_to := time.Now().UTC()
+	t_from := t_to.Add(-t_interval)
+	p_interval := t_interval.Milliseconds()
+	p_to := t_to.UnixMilli()
+	p_from := t_from.UnixMilli()
+
+	getAPIAnalyticsHitsParams := gravitee_analytics.GetAPIAnalyticsHitsParams{}
+	getAPIAnalyticsHitsParams.SetOrgID(c.OrgID)
+	getAPIAnalyticsHitsParams.SetEnvID(c.EnvID)
+	getAPIAnalyticsHitsParams.SetAPI(apiEndpoint.Status.ID)
+	getAPIAnalyticsHitsParams.SetType(p_type)
+	getAPIAnalyticsHitsParams.SetField(&p_field)
+	getAPIAnalyticsHitsParams.SetInterval(&p_interval)
+	getAPIAnalyticsHitsParams.SetFrom(&p_from)
+	getAPIAnalyticsHitsParams.SetTo(&p_to)
+	getAPIAnalyticsHitsParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getAPIAnalyticsHitsParams.SetContext(ctx)
+	results, err := c.client_analytics.GetAPIAnalyticsHits(&getAPIAnalyticsHitsParams, c.authInfo)
+	if err != nil {
+		l.Printf("unable to GetAPIAnalyticsHits %s", err)
+		return nil, classify(err, "unable to get the path mapping analytics of API %s", apiEndpoint.Status.ID)
+	}
+	payload, ok := results.Payload.(map[string]interface{})
+	if !ok {
+		return nil, newManagementError(ErrorTransient, "unexpected path mapping analytics %v of API %s", results.Payload, apiEndpoint.Status.ID)
+	}
+	values, ok := payload["values"].(map[string]interface{})
+	if !ok && payload["values"] != nil {
+		return nil, newManagementError(ErrorTransient, "unexpected path mapping analytics %v of API %s", payload["values"], apiEndpoint.Status.ID)
+	}
+	analytics, err := analyticsValues(values)
+	if err != nil {
+		return nil, newManagementError(ErrorTransient, "unexpected path mapping analytics of API %s: %w", apiEndpoint.Status.ID, err)
+	}
+	return analytics, nil
+}
+
+// planMatches tells whether a plan of the CRD is the given Gravitee plan. The key of a plan is
+// stored as the cross ID of the Gravitee plan, which is matched by key so that it can be renamed
+// in place. Other plans, and the plans without a cross ID yet, are matched by name, unless the
+// Gravitee plan holds the key of another plan of the CRD.
+func planMatches(plan_new *platformv1beta1.Plan, plan_ext *gravitee_models.PlanEntity, plans_new []*platformv1beta1.Plan) bool {
+	if plan_ext.CrossID != "" {
+		if plan_new.Key == plan_ext.CrossID {
+			return true
+		}
+		for _, other := range plans_new {
+			if other.Key == plan_ext.CrossID {
+				return false
+			}
+		}
+	}
+	return plan_new.Name != nil && *plan_new.Name == plan_ext.Name
+}
+
+// apiEndpointPlan returns the plan of the APIEndpoint with the given name or key
+func apiEndpointPlan(apiEndpoint *platformv1beta1.APIEndpoint, ref string) *platformv1beta1.Plan {
+	for _, plan_new := range apiEndpoint.Spec.Plans {
+		if (plan_new.Name != nil && *plan_new.Name == ref) || (plan_new.Key != "" && plan_new.Key == ref) {
+			return plan_new
+		}
+	}
+	return nil
+}
+
+// apiEndpointPageID returns the ID of a page of the APIEndpoint, reported in its status once the
+// pages are updated
+func apiEndpointPageID(apiEndpoint *platformv1beta1.APIEndpoint, name string) (string, error) {
+	declared := false
+	for _, page_new := range apiEndpoint.Spec.Pages {
+		declared = declared || page_new.Name == name
+	}
+	if !declared {
+		return "", newManagementError(ErrorValidation, "page %s is not declared in the pages of APIEndpoint %s", name, apiEndpoint.Name)
+	}
+	for _, page_status := range apiEndpoint.Status.Pages {
+		if page_status.Name == name && page_status.ID != "" {
+			return page_status.ID, nil
+		}
+	}
+	return "", newManagementError(ErrorNotFound, "page %s of APIEndpoint %s not found", name, apiEndpoint.Name)
+}
+
+// previousPlanStatus returns the status of a plan reported by the last update, if any
+func previousPlanStatus(apiEndpoint *platformv1beta1.APIEndpoint, plan_new *platformv1beta1.Plan) *platformv1beta1.PlanStatus {
+	for i, plan_status := range apiEndpoint.Status.Plans {
+		if (plan_new.Key != "" && plan_status.Key == plan_new.Key) || (plan_new.Key == "" && plan_status.Name == *plan_new.Name) {
+			return &apiEndpoint.Status.Plans[i]
+		}
+	}
+	return nil
+}
+
+// planStatus returns the status a plan should have, the status field wins over the deprecated flag
+func planStatus(plan_new *platformv1beta1.Plan) string {
+	if plan_new.Status != "" {
+		return plan_new.Status
+	}
+	if plan_new.Deprecated {
+		return "DEPRECATED"
+	}
+	return "PUBLISHED"
+}
+
+// Helper function to read a string from a subscription returned as a generic map.
+func subscriptionString(sub map[string]interface{}, key string) string {
+	value, _ := sub[key].(string)
+	return value
+}
+
+// Helper function to find, among subscriptions returned as generic maps, the one a decision has
+// been applied to: the subscription with the given ID, or else the last processed one.
+func processedSubscription(subs []map[string]interface{}, subscriptionID string) map[string]interface{} {
+	var processed map[string]interface{}
+	for _, sub := range subs {
+		if subscriptionID != "" && subscriptionString(sub, "id") == subscriptionID {
+			return sub
+		}
+		if processed == nil || subscriptionTimestamp(sub, "processed_at") > subscriptionTimestamp(processed, "processed_at") {
+			processed = sub
+		}
+	}
+	return processed
+}
+
+// Helper function to read the numbers of analytics returned as a generic map, the missing
+// values being skipped.
+func analyticsValues(values map[string]interface{}) (map[string]float64, error) {
+	analytics := make(map[string]float64)
+	for k, v := range values {
+		if v == nil {
+			continue
+		}
+		number, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a number: %v", k, v)
+		}
+		value, err := number.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a number: %w", k, err)
+		}
+		analytics[k] = value
+	}
+	return analytics, nil
+}
+
+// Helper function to read a timestamp from a subscription returned as a generic map.
+func subscriptionTimestamp(sub map[string]interface{}, key string) int64 {
+	if ts, ok := sub[key].(json.Number); ok {
+		v, _ := ts.Int64()
+		return v
+	}
+	return 0
+}
+
+// Helper function to compute the dates of a subscription returned as a generic map. The dates
+// the spec leaves out keep their value in API management, as an approval may have set them.
+func subscriptionDates(sub_new *platformv1beta1.APISubscription, sub map[string]interface{}) (startingAt int64, endingAt int64, changed bool) {
+	startingAt = subscriptionTimestamp(sub, "starting_at")
+	endingAt = subscriptionTimestamp(sub, "ending_at")
+	if sub_new.StartingAt != nil && sub_new.StartingAt.UnixMilli() != startingAt {
+		startingAt = sub_new.StartingAt.UnixMilli()
+		changed = true
+	}
+	if sub_new.EndingAt != nil && sub_new.EndingAt.UnixMilli() != endingAt {
+		endingAt = sub_new.EndingAt.UnixMilli()
+		changed = true
+	}
+	return
+}
+
 // Helper functions to check and remove string from a slice of strings.
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
@@ -0,0 +1,208 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	platformv1beta1 "my.domain/platform/gk8soperator/api/v1beta1"
+	gravitee_models "my.domain/platform/gk8soperator/pkg/gravitee/models"
+)
+
+func TestPlanMatches(t *testing.T) {
+	name := func(s string) *string { return &s }
+	plans_new := []*platformv1beta1.Plan{
+		{Name: name("platinum"), Key: "premium"},
+		{Name: name("silver"), Key: "standard"},
+		{Name: name("bronze")},
+	}
+	tests := []struct {
+		name     string
+		plan_new *platformv1beta1.Plan
+		plan_ext *gravitee_models.PlanEntity
+		want     bool
+	}{
+		{"same name without key", &platformv1beta1.Plan{Name: name("gold")}, &gravitee_models.PlanEntity{ID: "plan-1", Name: "gold"}, true},
+		{"other name without key", &platformv1beta1.Plan{Name: name("gold")}, &gravitee_models.PlanEntity{ID: "plan-1", Name: "silver"}, false},
+		{"renamed plan matched by key", plans_new[0], &gravitee_models.PlanEntity{ID: "plan-1", Name: "gold", CrossID: "premium"}, true},
+		{"key of another plan", plans_new[1], &gravitee_models.PlanEntity{ID: "plan-1", Name: "silver", CrossID: "premium"}, false},
+		{"key of another plan without key", plans_new[2], &gravitee_models.PlanEntity{ID: "plan-1", Name: "bronze", CrossID: "premium"}, false},
+		{"plan without cross ID matched by name", plans_new[1], &gravitee_models.PlanEntity{ID: "plan-2", Name: "silver"}, true},
+		{"removed key matched by name", plans_new[2], &gravitee_models.PlanEntity{ID: "plan-3", Name: "bronze", CrossID: "basic"}, true},
+		{"no name", &platformv1beta1.Plan{Key: "basic"}, &gravitee_models.PlanEntity{ID: "plan-4", Name: "bronze"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planMatches(tt.plan_new, tt.plan_ext, plans_new); got != tt.want {
+				t.Errorf("planMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIEndpointPlan(t *testing.T) {
+	name := func(s string) *string { return &s }
+	apiEndpoint := &platformv1beta1.APIEndpoint{
+		Spec: platformv1beta1.APIEndpointSpec{
+			Plans: []*platformv1beta1.Plan{
+				{Name: name("gold"), Key: "premium"},
+				{Name: name("silver")},
+			},
+		},
+	}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"gold", "gold"},
+		{"premium", "gold"},
+		{"silver", "silver"},
+		{"bronze", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got := ""
+			if plan := apiEndpointPlan(apiEndpoint, tt.ref); plan != nil {
+				got = *plan.Name
+			}
+			if got != tt.want {
+				t.Errorf("apiEndpointPlan(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyticsValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"numbers", map[string]interface{}{"count": json.Number("12"), "avg": json.Number("3.5")}, map[string]float64{"count": 12, "avg": 3.5}, false},
+		{"missing values skipped", map[string]interface{}{"count": json.Number("0"), "avg": nil}, map[string]float64{"count": 0}, false},
+		{"no values", nil, map[string]float64{}, false},
+		{"not a number", map[string]interface{}{"count": "twelve"}, nil, true},
+		{"invalid number", map[string]interface{}{"count": json.Number("1e")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := analyticsValues(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("analyticsValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("analyticsValues() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("analyticsValues()[%s] = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestSubscriptionDates(t *testing.T) {
+	date := func(ms int64) *metav1.Time { t := metav1.NewTime(time.UnixMilli(ms)); return &t }
+	sub := map[string]interface{}{"starting_at": json.Number("1000"), "ending_at": json.Number("2000")}
+	tests := []struct {
+		name           string
+		sub_new        platformv1beta1.APISubscription
+		sub            map[string]interface{}
+		wantStartingAt int64
+		wantEndingAt   int64
+		wantChanged    bool
+	}{
+		{"no dates in spec keeps the existing ones", platformv1beta1.APISubscription{}, sub, 1000, 2000, false},
+		{"same dates", platformv1beta1.APISubscription{StartingAt: date(1000), EndingAt: date(2000)}, sub, 1000, 2000, false},
+		{"new ending date keeps the starting one", platformv1beta1.APISubscription{EndingAt: date(3000)}, sub, 1000, 3000, true},
+		{"new starting date keeps the ending one", platformv1beta1.APISubscription{StartingAt: date(500)}, sub, 500, 2000, true},
+		{"dates of a new subscription", platformv1beta1.APISubscription{StartingAt: date(1000)}, map[string]interface{}{"id": "sub-1"}, 1000, 0, true},
+		{"no dates at all", platformv1beta1.APISubscription{}, map[string]interface{}{"id": "sub-1"}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startingAt, endingAt, changed := subscriptionDates(&tt.sub_new, tt.sub)
+			if startingAt != tt.wantStartingAt || endingAt != tt.wantEndingAt || changed != tt.wantChanged {
+				t.Errorf("subscriptionDates() = %d, %d, %v, want %d, %d, %v", startingAt, endingAt, changed, tt.wantStartingAt, tt.wantEndingAt, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestAPIEndpointPageID(t *testing.T) {
+	apiEndpoint := &platformv1beta1.APIEndpoint{
+		Spec: platformv1beta1.APIEndpointSpec{
+			Pages: []*platformv1beta1.Page{{Name: "Home"}, {Name: "OpenAPI"}},
+		},
+		Status: platformv1beta1.APIEndpointStatus{
+			Pages: []platformv1beta1.PageStatus{{Name: "Home", ID: "page-1"}, {Name: "Removed", ID: "page-2"}},
+		},
+	}
+	tests := []struct {
+		name      string
+		want      string
+		wantClass ErrorClass
+	}{
+		{"Home", "page-1", ""},
+		{"OpenAPI", "", ErrorNotFound},
+		{"Removed", "", ErrorValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := apiEndpointPageID(apiEndpoint, tt.name)
+			if got != tt.want || (err != nil) != (tt.wantClass != "") || (err != nil && ErrorClassOf(err) != tt.wantClass) {
+				t.Errorf("apiEndpointPageID(%q) = %q, %v, want %q, %q", tt.name, got, err, tt.want, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestProcessedSubscription(t *testing.T) {
+	subs := []map[string]interface{}{
+		{"id": "sub-1", "status": "ACCEPTED", "processed_at": json.Number("1000")},
+		{"id": "sub-2", "status": "ACCEPTED", "processed_at": json.Number("3000")},
+		{"id": "sub-3", "status": "ACCEPTED", "processed_at": json.Number("2000")},
+	}
+	tests := []struct {
+		name           string
+		subs           []map[string]interface{}
+		subscriptionID string
+		want           string
+	}{
+		{"recorded subscription", subs, "sub-1", "sub-1"},
+		{"last processed subscription", subs, "", "sub-2"},
+		{"recorded subscription processed otherwise", subs, "sub-4", "sub-2"},
+		{"no subscription", nil, "sub-1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subscriptionString(processedSubscription(tt.subs, tt.subscriptionID), "id")
+			if got != tt.want {
+				t.Errorf("processedSubscription() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	l "log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	gravitee_user "my.domain/platform/gk8soperator/pkg/gravitee/client/current_user"
+	gravitee_env "my.domain/platform/gk8soperator/pkg/gravitee/client/environment"
+)
+
+const (
+	// the connections are checked this often, the readiness check reading the last results
+	healthCheckPeriod = 30 * time.Second
+	// a health check gives up after this long
+	healthCheckTimeout = 10 * time.Second
+)
+
+var connectionUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gravitee_operator_connection_up",
+		Help: "Whether the last health check of a management API connection succeeded",
+	},
+	[]string{"connection"})
+
+func init() {
+	metrics.Registry.MustRegister(connectionUp)
+}
+
+// healthStatus keeps the result of the last health check of a connection
+type healthStatus struct {
+	mu  sync.Mutex
+	err error
+}
+
+// Health returns the result of the last health check, nil until the first one
+func (c *APIController) Health() error {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	return c.health.err
+}
+
+// CheckHealth makes an authenticated management API call, reading the current user, and
+// keeps the result for Health
+func (c *APIController) CheckHealth(ctx context.Context) error {
+	getCurrentUserParams := gravitee_user.NewGetCurrentUserParams()
+	getCurrentUserParams.WithDefaults()
+	getCurrentUserParams.SetOrgID(c.OrgID)
+	getCurrentUserParams.SetEnvID(c.EnvID)
+	getCurrentUserParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getCurrentUserParams.SetContext(ctx)
+	_, err := c.client_user.GetCurrentUser(getCurrentUserParams, c.authInfo)
+	if err != nil {
+		l.Printf("health check of connection %s failed: %s", c.Name, err)
+		err = classify(err, "unable to get the current user")
+		connectionUp.WithLabelValues(c.Name).Set(0)
+	} else {
+		connectionUp.WithLabelValues(c.Name).Set(1)
+	}
+	c.health.mu.Lock()
+	c.health.err = err
+	c.health.mu.Unlock()
+	return err
+}
+
+// ValidateEnvironment checks that the organization and the environment exist, returning a
+// validation error when they do not. The other errors are only logged, as the management
+// API may be briefly unavailable.
+func (c *APIController) ValidateEnvironment(ctx context.Context) error {
+	getEnvironmentParams := gravitee_env.NewGetEnvironmentParams()
+	getEnvironmentParams.WithDefaults()
+	getEnvironmentParams.SetOrgID(c.OrgID)
+	getEnvironmentParams.SetEnvID(c.EnvID)
+	getEnvironmentParams.SetTimeout(time.Second * time.Duration(c.Timeout))
+	getEnvironmentParams.SetContext(ctx)
+	_, err := c.client_env.GetEnvironment(getEnvironmentParams, c.authInfo)
+	if IsNotFound(err) {
+		return newManagementError(ErrorValidation, "organization %s or environment %s not found", c.OrgID, c.EnvID)
+	}
+	if err != nil {
+		l.Printf("unable to check environment %s of organization %s of connection %s: %s", c.EnvID, c.OrgID, c.Name, err)
+	}
+	return nil
+}
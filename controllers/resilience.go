@@ -0,0 +1,356 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	l "log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// backoff of the retries: the first one waits up to retryBaseDelay, each next one up to
+// twice as long, up to retryMaxDelay
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// the methods of the management API calls that can be sent again
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+var circuitBreakerState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gravitee_operator_circuit_breaker_state",
+		Help: "State of the circuit breaker of a management API connection: 0 closed, 1 open, 2 half-open",
+	},
+	[]string{"connection"})
+
+func init() {
+	metrics.Registry.MustRegister(circuitBreakerState)
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = map[breakerState]string{
+	breakerClosed:   "closed",
+	breakerOpen:     "open",
+	breakerHalfOpen: "half-open",
+}
+
+// circuitBreaker opens after consecutive failed calls, refusing the calls for the open
+// period, then lets a single call probe the management API and closes when it succeeds
+type circuitBreaker struct {
+	name       string
+	threshold  int
+	openPeriod time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(name string, threshold int, openPeriod time.Duration) *circuitBreaker {
+	b := &circuitBreaker{name: name, threshold: threshold, openPeriod: openPeriod}
+	circuitBreakerState.WithLabelValues(name).Set(float64(breakerClosed))
+	return b
+}
+
+// Allow tells whether a call can be made
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openPeriod {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success records a call answered by the management API
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+// Failure records a call failed because of the management API
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// Release records a call given up by the caller, which tells nothing about the management API
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Wait returns how long the breaker stays open, zero when the calls are allowed
+func (b *circuitBreaker) Wait() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	if wait := b.openPeriod - time.Since(b.openedAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	l.Printf("circuit breaker of connection %s %s", b.name, breakerStateNames[state])
+	b.state = state
+	circuitBreakerState.WithLabelValues(b.name).Set(float64(state))
+}
+
+// resilientTransport sends the management API calls through the circuit breaker, each
+// attempt having its own timeout, and sends the idempotent ones again, after a jittered
+// exponential backoff, when they fail because of the management API. The calls throttled
+// with a 429 answer are sent again too, after the Retry-After delay when it is longer.
+type resilientTransport struct {
+	next           http.RoundTripper
+	breaker        *circuitBreaker
+	maxRetries     int
+	attemptTimeout time.Duration
+}
+
+// callTimeout returns the timeout of a whole management API call, in seconds, leaving each
+// attempt the timeout of the configuration and the longest backoff between them
+func callTimeout(config *Config) int {
+	return config.Timeout*(config.MaxRetries+1) + int(retryMaxDelay/time.Second)*config.MaxRetries
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if !t.breaker.Allow() {
+			return nil, newManagementError(ErrorTransient, "management API of connection %s unavailable, circuit breaker open", t.breaker.name)
+		}
+		attemptCtx, cancel := context.WithCancel(req.Context())
+		if t.attemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(req.Context(), t.attemptTimeout)
+		}
+		attemptReq := req.Clone(attemptCtx)
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+			attemptReq.ContentLength = int64(len(body))
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if err != nil && req.Context().Err() != nil {
+			cancel()
+			t.breaker.Release()
+			return nil, err
+		}
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		throttled := err == nil && resp.StatusCode == http.StatusTooManyRequests
+		switch {
+		case failed:
+			t.breaker.Failure()
+		case throttled:
+			// the management API is up but asks to slow down
+			t.breaker.Release()
+		default:
+			t.breaker.Success()
+		}
+		// a throttled call has not been processed, it can be sent again whatever its method
+		retry := (failed && idempotentMethods[req.Method]) || throttled
+		if !retry || attempt >= t.maxRetries {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			// the attempt lasts until its answer is read
+			resp.Body = &cancelingBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		delay := retryDelay(attempt)
+		if resp != nil {
+			if throttled {
+				if after := retryAfter(resp); after > delay {
+					delay = after
+				}
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryDelay returns a random delay up to the exponential backoff of the attempt
+func retryDelay(attempt int) time.Duration {
+	backoff := retryMaxDelay
+	if attempt < 16 {
+		if delay := retryBaseDelay << attempt; delay < retryMaxDelay {
+			backoff = delay
+		}
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+// retryAfter returns the delay asked by the Retry-After header of an answer, in seconds or
+// as a date, up to retryMaxDelay
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
+
+// cancelingBody ends the attempt of a call when its answer is closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// limitedTransport limits the management API calls of a connection to a rate, with a
+// token bucket, and to a number of calls in flight
+type limitedTransport struct {
+	next     http.RoundTripper
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+}
+
+// newLimitedTransport returns the transport limiting the calls to rateLimit per second,
+// with bursts of up to burst calls, and to maxInFlight calls in flight, zero meaning no limit
+func newLimitedTransport(next http.RoundTripper, rateLimit int, burst int, maxInFlight int) http.RoundTripper {
+	if rateLimit <= 0 && maxInFlight <= 0 {
+		return next
+	}
+	t := &limitedTransport{next: next}
+	if rateLimit > 0 {
+		if burst <= 0 {
+			burst = rateLimit
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(rateLimit), burst)
+	}
+	if maxInFlight > 0 {
+		t.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return t
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.limiter != nil {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	if t.inFlight == nil {
+		return t.next.RoundTrip(req)
+	}
+	select {
+	case t.inFlight <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	release := &sync.Once{}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release.Do(func() { <-t.inFlight })
+		return nil, err
+	}
+	// the call is in flight until its answer is read
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { release.Do(func() { <-t.inFlight }) }}
+	return resp, nil
+}
+
+// releasingBody releases the in-flight slot of a call when its answer is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	type step struct {
+		action    string
+		wantAllow bool
+		wantState breakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed under the threshold", []step{
+			{"failure", true, breakerClosed},
+			{"failure", true, breakerClosed},
+		}},
+		{"opens at the threshold", []step{
+			{"failure", true, breakerClosed},
+			{"failure", true, breakerClosed},
+			{"failure", false, breakerOpen},
+		}},
+		{"a success resets the failures", []step{
+			{"failure", true, breakerClosed},
+			{"failure", true, breakerClosed},
+			{"success", true, breakerClosed},
+			{"failure", true, breakerClosed},
+		}},
+		{"half-open after the open period, closed by a successful probe", []step{
+			{"failure", true, breakerClosed},
+			{"failure", true, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"wait", true, breakerHalfOpen},
+			{"success", true, breakerClosed},
+		}},
+		{"opened again by a failed probe", []step{
+			{"failure", true, breakerClosed},
+			{"failure", true, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"wait", true, breakerHalfOpen},
+			{"failure", false, breakerOpen},
+		}},
+		{"a released probe lets another call probe", []step{
+			{"failure", true, breakerClosed},
+			{"failure", true, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"wait", true, breakerHalfOpen},
+			{"release", true, breakerHalfOpen},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker("test", 3, 20*time.Millisecond)
+			for i, s := range tt.steps {
+				switch s.action {
+				case "failure":
+					b.Failure()
+				case "success":
+					b.Success()
+				case "release":
+					b.Release()
+				case "wait":
+					time.Sleep(25 * time.Millisecond)
+				}
+				// the probe of a half-open breaker is allowed once
+				if allow := b.Allow(); allow != s.wantAllow {
+					t.Errorf("step %d %s: Allow() = %v, want %v", i, s.action, allow, s.wantAllow)
+				}
+				if b.state != s.wantState {
+					t.Errorf("step %d %s: state = %s, want %s", i, s.action, breakerStateNames[b.state], breakerStateNames[s.wantState])
+				}
+				if s.wantAllow && b.state == breakerHalfOpen && b.Allow() {
+					t.Errorf("step %d %s: a second probe is allowed", i, s.action)
+				}
+				b.Release()
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, retryBaseDelay},
+		{1, 2 * retryBaseDelay},
+		{3, 8 * retryBaseDelay},
+		{5, retryMaxDelay},
+		{16, retryMaxDelay},
+		{100, retryMaxDelay},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if delay := retryDelay(tt.attempt); delay <= 0 || delay > tt.max {
+				t.Fatalf("retryDelay(%d) = %s, want in (0, %s]", tt.attempt, delay, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"none", "", 0},
+		{"seconds", "3", 3 * time.Second},
+		{"capped", "120", retryMaxDelay},
+		{"negative", "-1", 0},
+		{"past date", "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+		{"invalid", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			if got := retryAfter(resp); got != tt.want {
+				t.Errorf("retryAfter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// roundTripperFunc answers the calls of a test
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func answer(status int) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}
+}
+
+func TestResilientTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		answers      []int
+		wantStatus   int
+		wantAttempts int
+		wantFailures int
+	}{
+		{"success", http.MethodGet, []int{200}, 200, 1, 0},
+		{"retried server error", http.MethodGet, []int{503, 200}, 200, 2, 0},
+		{"retries exhausted", http.MethodGet, []int{500, 500, 500}, 500, 3, 3},
+		{"server error of a POST not retried", http.MethodPost, []int{500, 200}, 500, 1, 1},
+		{"throttled POST retried", http.MethodPost, []int{429, 201}, 201, 2, 0},
+		{"throttling not counted as a failure", http.MethodGet, []int{429, 429, 429}, 429, 3, 0},
+		{"client error not retried", http.MethodPut, []int{400, 200}, 400, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				status := tt.answers[attempts]
+				attempts++
+				return answer(status), nil
+			})
+			breaker := newCircuitBreaker("test", 10, time.Minute)
+			transport := &resilientTransport{next: next, breaker: breaker, maxRetries: 2, attemptTimeout: time.Second}
+			req, _ := http.NewRequest(tt.method, "http://apim.my.domain/management", strings.NewReader("{}"))
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("RoundTrip() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if breaker.failures != tt.wantFailures {
+				t.Errorf("breaker failures = %d, want %d", breaker.failures, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestResilientTransportAttemptTimeout(t *testing.T) {
+	attempts := 0
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			// the first attempt hangs until its own deadline
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		if _, ok := req.Context().Deadline(); !ok {
+			t.Errorf("attempt %d has no deadline", attempts)
+		}
+		return answer(200), nil
+	})
+	transport := &resilientTransport{next: next, breaker: newCircuitBreaker("test", 10, time.Minute), maxRetries: 2, attemptTimeout: 20 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://apim.my.domain/management", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || attempts != 2 {
+		t.Errorf("RoundTrip() status = %d after %d attempts, want 200 after 2", resp.StatusCode, attempts)
+	}
+}
+
+func TestResilientTransportCanceled(t *testing.T) {
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	breaker := newCircuitBreaker("test", 1, time.Minute)
+	transport := &resilientTransport{next: next, breaker: breaker, maxRetries: 2, attemptTimeout: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://apim.my.domain/management", nil)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RoundTrip() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if breaker.state != breakerClosed {
+		t.Errorf("breaker state = %s, want closed", breakerStateNames[breaker.state])
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of the TLS Secrets of the management API transport
+const (
+	TLSSecretCAKey   = "ca.crt"
+	TLSSecretCertKey = v1.TLSCertKey
+	TLSSecretKeyKey  = v1.TLSPrivateKeyKey
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newHTTPTransport returns the transport of the management API calls, with the CA bundle,
+// client certificate, minimum TLS version and proxy of the configuration. The CA bundle
+// and client certificate of the TLS Secret, when given, are used too.
+func newHTTPTransport(config *Config, tlsSecret *v1.Secret) (http.RoundTripper, error) {
+	tlsConfig := &tls.Config{MinVersion: tlsVersions[config.MinTLSVersion]}
+
+	caBundle := make([]byte, 0)
+	if config.CAFile != "" {
+		ca_raw, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fileError(err, "unable to read ca_file")
+		}
+		caBundle = append(caBundle, ca_raw...)
+	}
+	if tlsSecret != nil {
+		caBundle = append(caBundle, tlsSecret.Data[TLSSecretCAKey]...)
+	}
+	if len(caBundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no PEM certificate found in the CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fileError(err, "unable to load the client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if tlsSecret != nil && len(tlsSecret.Data[TLSSecretCertKey]) > 0 {
+		cert, err := tls.X509KeyPair(tlsSecret.Data[TLSSecretCertKey], tlsSecret.Data[TLSSecretKeyKey])
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate of Secret %s: %w", tlsSecret.Name, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return transport, nil
+}
+
+// fileError wraps the error of a file read. A missing or unreadable file, e.g. a Secret not
+// mounted yet, is a transient error, while invalid contents are not classified.
+func fileError(err error, format string, a ...interface{}) error {
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		return &ManagementError{Class: ErrorTransient, Err: fmt.Errorf(format+": %w", append(a, err)...)}
+	}
+	return fmt.Errorf(format+": %w", append(a, err)...)
+}
+
+// newTokenEndpointClient returns the HTTP client of the OAuth2 token endpoint, apart from the
+// management API transport: it trusts the system CAs and the CA bundle of token_ca_file, and
+// goes through the proxy of the configuration, without the management API client certificate,
+// rate limits and circuit breaker.
+func newTokenEndpointClient(config *Config) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tlsVersions[config.MinTLSVersion]}
+	if config.TokenCAFile != "" {
+		ca_raw, err := os.ReadFile(config.TokenCAFile)
+		if err != nil {
+			return nil, fileError(err, "unable to read token_ca_file")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca_raw) {
+			return nil, fmt.Errorf("no PEM certificate found in token_ca_file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport, Timeout: time.Second * time.Duration(config.Timeout)}, nil
+}
+
+// readTLSSecret reads the TLS Secret of the configuration, given as namespace/name
+func readTLSSecret(ctx context.Context, reader client.Reader, ref string) (*v1.Secret, error) {
+	if ref == "" {
+		return nil, nil
+	}
+	namespace, name, _ := strings.Cut(ref, "/")
+	secret := v1.Secret{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+		return nil, fmt.Errorf("unable to read tls_secret %s: %w", ref, err)
+	}
+	return &secret, nil
+}
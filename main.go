@@ -19,12 +19,14 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -57,6 +59,14 @@ func main() {
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	var configFile string
+	var configSecret string
+	var configSecretKey string
+	flag.StringVar(&configFile, "config-file", os.Getenv("GRAVITEE_OPERATOR_CONFIG_FILE"), "The operator configuration file.")
+	flag.StringVar(&configSecret, "config-secret", os.Getenv("GRAVITEE_OPERATOR_CONFIG_SECRET"),
+		"The Secret holding the operator configuration, as namespace/name, read instead of the configuration file.")
+	flag.StringVar(&configSecretKey, "config-secret-key", "config.yaml", "The key of the operator configuration in the Secret.")
+	configOverrides := controllers.BindConfigFlags(flag.CommandLine)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,20 +88,52 @@ func main() {
 		os.Exit(1)
 	}
 
+	configSource := controllers.ConfigSource{File: configFile, SecretKey: configSecretKey}
+	if configSecret != "" {
+		namespace, name, found := strings.Cut(configSecret, "/")
+		if !found {
+			setupLog.Error(nil, "invalid configuration Secret, expecting namespace/name", "config-secret", configSecret)
+			os.Exit(1)
+		}
+		configSource.Secret = &types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	connections, err := controllers.NewConnections(mgr.GetClient(), mgr.GetAPIReader(), configSource, configOverrides)
+	if err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+	if err = mgr.Add(connections); err != nil {
+		setupLog.Error(err, "unable to set up configuration reload")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.APIEndpointReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Connections:             connections,
+		MaxConcurrentReconciles: connections.Config().MaxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "APIEndpoint")
 		os.Exit(1)
 	}
 	if err = (&controllers.APIClientReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Connections:             connections,
+		MaxConcurrentReconciles: connections.Config().MaxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "APIClient")
 		os.Exit(1)
 	}
+	if err = (&controllers.APISubscriptionApprovalReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Connections:             connections,
+		MaxConcurrentReconciles: connections.Config().MaxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "APISubscriptionApproval")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -102,6 +144,14 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("circuit-breakers", connections.CircuitBreakersCheck); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("management-api", connections.HealthCheck); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {